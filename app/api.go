@@ -1,21 +1,28 @@
 package app
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/fiffu/diffwatch/config"
 	"github.com/fiffu/diffwatch/lib"
 	"github.com/fiffu/diffwatch/lib/models"
+	"github.com/fiffu/diffwatch/lib/proxies"
+	"github.com/fiffu/diffwatch/lib/snapshotter"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"go.uber.org/fx"
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
 func NewAPI(lc fx.Lifecycle, cfg *config.Config, log *zap.Logger, svc *lib.Service) *http.Server {
@@ -52,12 +59,23 @@ func router(cfg *config.Config, log *zap.Logger, svc *lib.Service) http.Handler
 	r.Route("/api", func(r chi.Router) {
 		r.Route("/users", func(r chi.Router) {
 			r.Post("/", ctrl.onboardUser)
+			r.Put("/{user_id}/notifier", ctrl.updateNotifier)
 			r.Post("/{user_id}/subscriptions", ctrl.subscribe)
 			r.Get("/{user_id}/subscriptions", ctrl.listSubscriptions)
 			r.Get("/{user_id}/subscriptions/{subscription_id}/latest", ctrl.viewSnapshot)
+			r.Get("/{user_id}/subscriptions/{subscription_id}/diff", ctrl.viewSnapshotDiff)
+			r.Get("/{user_id}/subscriptions/{subscription_id}/history", ctrl.listSnapshots)
+			r.Get("/{user_id}/subscriptions/{subscription_id}/archive/{digest}", ctrl.downloadArchive)
 			r.Post("/{user_id}/subscriptions/{subscription_id}/push", ctrl.pushSnapshot)
+			r.Post("/{user_id}/subscriptions/{subscription_id}/resume", ctrl.resumeSubscription)
+			r.Get("/{user_id}/subscriptions/{subscription_id}/preview", ctrl.previewNormalization)
 		})
 	})
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(middleware.BasicAuth("diffwatch", cfg.GetCreds()))
+		r.Get("/intervals", ctrl.viewIntervals)
+		r.Put("/intervals", ctrl.updateIntervals)
+	})
 	r.Get("/verify/{nonce}", ctrl.verifyNotifier)
 
 	return r
@@ -132,10 +150,81 @@ func (ctrl *apiController) onboardUser(w http.ResponseWriter, r *http.Request) {
 func (ctrl *apiController) subscribe(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	userID := chi.URLParam(r, "user_id")
-	endpoint := r.FormValue("endpoint")
-	xpath := r.FormValue("xpath")
+	kind, selector, err := parseSelector(r)
+	if err != nil {
+		ctrl.reject(w, 400, err)
+		return
+	}
+	request, err := parseRequestSpec(r)
+	if err != nil {
+		ctrl.reject(w, 400, err)
+		return
+	}
+	rules, err := parseRules(r)
+	if err != nil {
+		ctrl.reject(w, 400, err)
+		return
+	}
+	normalize, err := parseNormalizeSteps(r)
+	if err != nil {
+		ctrl.reject(w, 400, err)
+		return
+	}
+	if kind == models.SelectorFeed && len(normalize) > 0 {
+		ctrl.reject(w, 400, errors.New("feed subscriptions take no normalize steps"))
+		return
+	}
+	proxyURL := r.FormValue("proxy_url")
+	if proxyURL != "" {
+		if _, err := proxies.Parse(proxyURL); err != nil {
+			ctrl.reject(w, 400, err)
+			return
+		}
+	}
+	schedule, timezone := r.FormValue("schedule"), r.FormValue("timezone")
+	if schedule != "" {
+		if _, err := models.ParseSchedule(schedule, timezone); err != nil {
+			ctrl.reject(w, 400, err)
+			return
+		}
+	} else if timezone != "" {
+		ctrl.reject(w, 400, errors.New("timezone requires a schedule"))
+		return
+	}
+	minInterval, maxInterval, err := parseIntervalBounds(r)
+	if err != nil {
+		ctrl.reject(w, 400, err)
+		return
+	}
+	if schedule != "" && (minInterval != 0 || maxInterval != 0) {
+		ctrl.reject(w, 400, errors.New("min_interval and max_interval only apply to subscriptions without a schedule"))
+		return
+	}
+	extractMode, extractAttr := models.ExtractMode(r.FormValue("extract")), r.FormValue("attr")
+	if extractMode == "" && extractAttr != "" {
+		extractMode = models.ExtractAttribute
+	}
+	params := lib.SubscriptionParams{
+		Endpoint:     r.FormValue("endpoint"),
+		SelectorKind: kind,
+		Selector:     selector,
+		MultiNode:    parseBool(r.FormValue("multi")),
+		ExtractMode:  extractMode,
+		ExtractAttr:  extractAttr,
+		Request:      request,
+		Charset:      r.FormValue("charset"),
+		Normalize:    normalize,
+		ProxyProfile: r.FormValue("proxy_profile"),
+		ProxyURL:     proxyURL,
+		Title:        r.FormValue("title"),
+		Rules:        rules,
+		Schedule:     schedule,
+		Timezone:     timezone,
+		MinInterval:  minInterval,
+		MaxInterval:  maxInterval,
+	}
 
-	snap, sub, err := ctrl.svc.CreateSubscription(ctx, parseUint(userID), endpoint, xpath)
+	snap, sub, err := ctrl.svc.CreateSubscription(ctx, parseUint(userID), params)
 	if err != nil {
 		ctrl.reject(w, 500, err)
 		return
@@ -143,8 +232,12 @@ func (ctrl *apiController) subscribe(w http.ResponseWriter, r *http.Request) {
 	ctrl.resolve(w, http.StatusOK, map[string]any{
 		"subscription_id": snap.SubscriptionID,
 		"content":         snap.Content,
-		"title":           sub.Title,
+		"items":           snap.Items,
+		"entries":         snap.Entries,
+		"title":           sub.DisplayTitle(),
 		"image_url":       sub.ImageURL,
+		"next_poll_at":    ISOFormatSQLTime(sub.NextPollAt),
+		"interval_reason": sub.IntervalReason,
 	})
 }
 
@@ -175,7 +268,76 @@ func (ctrl *apiController) viewSnapshot(w http.ResponseWriter, r *http.Request)
 	ctrl.resolve(w, 200, map[string]any{
 		"timestamp":      ISOFormatTime(snap.Timestamp),
 		"content":        snap.Content,
+		"items":          snap.Items,
+		"entries":        snap.Entries,
+		"fired_rule":     snap.FiredRule,
+		"reverted":       snap.Reverted,
+		"content_digest": snap.ContentDigest,
+		"archive_digest": snap.RawBodyDigest,
+	})
+}
+
+func (ctrl *apiController) listSnapshots(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := chi.URLParam(r, "user_id")
+	subscriptionID := chi.URLParam(r, "subscription_id")
+	limit, offset := ctrl.getPagination(r)
+
+	snaps, err := ctrl.svc.ListSnapshots(ctx, parseUint(userID), parseUint(subscriptionID), limit, offset)
+	if err != nil {
+		ctrl.reject(w, 500, err)
+		return
+	}
+	ctrl.resolve(w, 200, FromMany[*models.Snapshot, SnapshotView](snaps))
+}
+
+// downloadArchive serves a snapshot's archived response, with its headers prefixed by X-Archived-.
+func (ctrl *apiController) downloadArchive(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := chi.URLParam(r, "user_id")
+	subscriptionID := chi.URLParam(r, "subscription_id")
+	digest := chi.URLParam(r, "digest")
+
+	header, body, err := ctrl.svc.OpenArchive(ctx, parseUint(userID), parseUint(subscriptionID), digest)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound), errors.Is(err, fs.ErrNotExist):
+		ctrl.reject(w, 404, fmt.Errorf("no archived response %s", digest))
+		return
+	case err != nil:
+		ctrl.reject(w, 500, err)
+		return
+	}
+	defer body.Close()
+
+	for name, values := range header {
+		for _, value := range values {
+			w.Header().Add("X-Archived-"+name, value)
+		}
+	}
+	w.Header().Set("Content-Type", cmp.Or(header.Get("Content-Type"), "application/octet-stream"))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", digest))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, body); err != nil {
+		ctrl.log.Sugar().Errorw("Failed to send archived response", "digest", digest, "err", err)
+	}
+}
+
+func (ctrl *apiController) viewSnapshotDiff(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := chi.URLParam(r, "user_id")
+	subscriptionID := chi.URLParam(r, "subscription_id")
+
+	snap, hunks, err := ctrl.svc.FindSnapshotDiff(ctx, parseUint(userID), parseUint(subscriptionID))
+	if err != nil {
+		ctrl.reject(w, 500, err)
+		return
+	}
+	ctrl.resolve(w, 200, map[string]any{
+		"timestamp":      ISOFormatTime(snap.Timestamp),
 		"content_digest": snap.ContentDigest,
+		"hunks":          hunks,
+		"unified":        hunks.Unified(),
 	})
 }
 
@@ -192,14 +354,142 @@ func (ctrl *apiController) pushSnapshot(w http.ResponseWriter, r *http.Request)
 
 	resp := make(map[string]any)
 	resp["current"] = curr.Content
+	resp["fired_rule"] = curr.FiredRule
 	if prev != nil {
 		resp["previous"] = prev.Content
 	} else {
 		resp["previous"] = nil
 	}
+	if curr.IsList() {
+		var prevItems models.Items
+		if prev != nil {
+			prevItems = prev.Items
+		}
+		added, removed := models.DiffItems(prevItems, curr.Items)
+		resp["added_items"] = added
+		resp["removed_items"] = removed
+	}
+	if curr.IsFeed() {
+		var prevEntries models.FeedEntries
+		if prev != nil {
+			prevEntries = prev.Entries
+		}
+		added, edited := models.DiffFeedEntries(prevEntries, curr.Entries)
+		resp["new_entries"] = added
+		resp["edited_entries"] = edited
+	}
 	ctrl.resolve(w, 200, resp)
 }
 
+func (ctrl *apiController) viewIntervals(w http.ResponseWriter, r *http.Request) {
+	intervals, err := ctrl.svc.SnapshotterIntervals(r.Context())
+	if err != nil {
+		ctrl.reject(w, 500, err)
+		return
+	}
+	ctrl.resolve(w, http.StatusOK, IntervalsView{}.From(intervals))
+}
+
+func (ctrl *apiController) updateIntervals(w http.ResponseWriter, r *http.Request) {
+	var update snapshotter.Intervals
+	for key, interval := range map[string]*time.Duration{
+		"poll_interval":  &update.Poll,
+		"chase_interval": &update.Chase,
+	} {
+		value := r.FormValue(key)
+		if value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			ctrl.reject(w, 400, fmt.Errorf("invalid %s: %w", key, err))
+			return
+		}
+		*interval = d
+	}
+
+	if err := snapshotter.ValidateIntervals(update); err != nil {
+		ctrl.reject(w, 400, err)
+		return
+	}
+	intervals, err := ctrl.svc.SetSnapshotterIntervals(r.Context(), update)
+	if err != nil {
+		ctrl.reject(w, 500, err)
+		return
+	}
+	ctrl.resolve(w, http.StatusOK, IntervalsView{}.From(intervals))
+}
+
+func (ctrl *apiController) resumeSubscription(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := chi.URLParam(r, "user_id")
+	subscriptionID := chi.URLParam(r, "subscription_id")
+
+	err := ctrl.svc.ResumeSubscription(ctx, parseUint(userID), parseUint(subscriptionID))
+	if err != nil {
+		ctrl.reject(w, 500, err)
+		return
+	}
+	ctrl.resolve(w, http.StatusOK, map[string]any{"resumed": true})
+}
+
+func (ctrl *apiController) updateNotifier(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := chi.URLParam(r, "user_id")
+	mode := models.DeliveryMode(r.FormValue("delivery_mode"))
+	digestTime, timezone := r.FormValue("digest_time"), r.FormValue("timezone")
+
+	switch {
+	case mode == "":
+		ctrl.reject(w, 400, errors.New("delivery_mode is required"))
+		return
+	case mode == models.DeliverDaily && digestTime == "":
+		ctrl.reject(w, 400, errors.New("daily delivery requires digest_time, e.g. digest_time=08:00"))
+		return
+	case mode != models.DeliverDaily && (digestTime != "" || timezone != ""):
+		ctrl.reject(w, 400, errors.New("digest_time and timezone only apply to daily delivery"))
+		return
+	}
+	probe := models.Notifier{DeliveryMode: mode, DigestTime: digestTime, Timezone: timezone}
+	if _, err := probe.DigestSchedule(); err != nil {
+		ctrl.reject(w, 400, err)
+		return
+	}
+
+	notifier, err := ctrl.svc.SetNotifierDelivery(ctx, parseUint(userID), mode, digestTime, timezone)
+	if err != nil {
+		ctrl.reject(w, 500, err)
+		return
+	}
+	ctrl.resolve(w, http.StatusOK, NotifierView{}.From(notifier))
+}
+
+func (ctrl *apiController) previewNormalization(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := chi.URLParam(r, "user_id")
+	subscriptionID := chi.URLParam(r, "subscription_id")
+
+	// Steps given in the query are tried out in place of the subscription's own
+	steps, err := parseNormalizeSteps(r)
+	if err != nil {
+		ctrl.reject(w, 400, err)
+		return
+	}
+
+	raw, normalized, err := ctrl.svc.PreviewNormalization(ctx, parseUint(userID), parseUint(subscriptionID), steps)
+	if err != nil {
+		ctrl.reject(w, 500, err)
+		return
+	}
+	ctrl.resolve(w, http.StatusOK, map[string]any{
+		"raw":              raw.Text,
+		"raw_items":        raw.Items,
+		"normalized":       normalized.Text,
+		"normalized_items": normalized.Items,
+		"digest":           normalized.Digest(),
+	})
+}
+
 func (ctrl *apiController) verifyNotifier(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	nonce := chi.URLParam(r, "nonce")
@@ -212,6 +502,137 @@ func (ctrl *apiController) verifyNotifier(w http.ResponseWriter, r *http.Request
 	ctrl.resolve(w, http.StatusOK, map[string]any{"verified": ok})
 }
 
+// parseSelector reads the selector from whichever of the selector form fields was given, e.g. xpath= or css=.
+func parseSelector(r *http.Request) (models.SelectorKind, string, error) {
+	kinds := []models.SelectorKind{models.SelectorXPath, models.SelectorCSS, models.SelectorJSONPath}
+
+	var found models.SelectorKind
+	var expr string
+	for _, kind := range kinds {
+		value := r.FormValue(string(kind))
+		if value == "" {
+			continue
+		}
+		if found != "" {
+			return "", "", fmt.Errorf("only one of %s and %s can be given", found, kind)
+		}
+		found, expr = kind, value
+	}
+	if parseBool(r.FormValue("feed")) {
+		if found != "" {
+			return "", "", fmt.Errorf("feed subscriptions take no %s", found)
+		}
+		found = models.SelectorFeed
+	}
+	if found == "" {
+		return "", "", errors.New("Selector is required, e.g. xpath, css or jsonpath, or feed=true for RSS and Atom feeds")
+	}
+	return found, expr, nil
+}
+
+// parseRequestSpec reads the method, body, basic_auth, bearer_token and header fields of a subscription's request.
+func parseRequestSpec(r *http.Request) (models.RequestSpec, error) {
+	spec := models.RequestSpec{
+		Method:      strings.ToUpper(r.FormValue("method")),
+		Body:        r.FormValue("body"),
+		BearerToken: models.SealedString(r.FormValue("bearer_token")),
+	}
+
+	switch spec.Method {
+	case "", http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch:
+	default:
+		return spec, fmt.Errorf("unsupported method: %s", spec.Method)
+	}
+
+	for _, header := range formValues(r, "header") {
+		name, value, ok := strings.Cut(header, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return spec, fmt.Errorf("header must look like 'Name: value', got: %s", header)
+		}
+		if http.CanonicalHeaderKey(name) == "Accept-Encoding" {
+			// Setting it turns off the transparent decompression of responses
+			return spec, errors.New("the Accept-Encoding header can't be set")
+		}
+		if spec.Headers == nil {
+			spec.Headers = make(models.Headers)
+		}
+		http.Header(spec.Headers).Add(name, strings.TrimSpace(value))
+	}
+
+	if basicAuth := r.FormValue("basic_auth"); basicAuth != "" {
+		user, password, ok := strings.Cut(basicAuth, ":")
+		if !ok {
+			return spec, errors.New("basic_auth must look like 'user:password'")
+		}
+		spec.BasicUser, spec.BasicPassword = user, models.SealedString(password)
+	}
+	if spec.BasicUser != "" && spec.BearerToken != "" {
+		return spec, errors.New("only one of basic_auth and bearer_token can be given")
+	}
+	return spec, nil
+}
+
+// parseNormalizeSteps reads any number of normalize="op:argument" fields, applied in the order given.
+func parseNormalizeSteps(r *http.Request) (models.NormalizeSteps, error) {
+	var steps models.NormalizeSteps
+	for _, value := range formValues(r, "normalize") {
+		step, err := models.ParseNormalizeStep(value)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// parseRules reads any number of rule="kind:argument" fields; a change is notified if any of them fires.
+func parseRules(r *http.Request) (models.Rules, error) {
+	var rules models.Rules
+	for _, value := range formValues(r, "rule") {
+		rule, err := models.ParseRule(value)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// parseIntervalBounds parses the bounds of a subscription's adaptive poll interval, each of which is optional.
+func parseIntervalBounds(r *http.Request) (minInterval, maxInterval time.Duration, err error) {
+	for key, bound := range map[string]*time.Duration{
+		"min_interval": &minInterval,
+		"max_interval": &maxInterval,
+	} {
+		value := r.FormValue(key)
+		if value == "" {
+			continue
+		}
+		if *bound, err = time.ParseDuration(value); err != nil {
+			return 0, 0, fmt.Errorf("invalid %s: %w", key, err)
+		}
+		if *bound < models.MinPollInterval {
+			return 0, 0, fmt.Errorf("%s must be at least %s, got %s", key, models.MinPollInterval, *bound)
+		}
+	}
+	if minInterval != 0 && maxInterval != 0 && maxInterval < minInterval {
+		return 0, 0, fmt.Errorf("max_interval %s is less than min_interval %s", maxInterval, minInterval)
+	}
+	return minInterval, maxInterval, nil
+}
+
+// formValues returns every value given for a repeated form field.
+func formValues(r *http.Request, key string) []string {
+	r.FormValue(key) // parses the form if it hasn't been already
+	return r.Form[key]
+}
+
+func parseBool(s string) bool {
+	b, _ := strconv.ParseBool(s)
+	return b
+}
+
 func parseInt(s string) int {
 	return int(parseUint(s))
 }
@@ -0,0 +1,29 @@
+package app
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseRequestSpec(t *testing.T) {
+	parse := func(form url.Values) error {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		_, err := parseRequestSpec(r)
+		return err
+	}
+
+	if err := parse(url.Values{"header": {"Accept-Language: en", "X-Api-Key: secret"}}); err != nil {
+		t.Errorf("parseRequestSpec() = %v, want headers accepted", err)
+	}
+	for _, header := range []string{"Accept-Encoding: gzip", "accept-encoding: br"} {
+		if err := parse(url.Values{"header": {header}}); err == nil {
+			t.Errorf("parseRequestSpec() accepted %q, want an error", header)
+		}
+	}
+	if err := parse(url.Values{"basic_auth": {"user:password"}, "bearer_token": {"token"}}); err == nil {
+		t.Error("parseRequestSpec() accepted both basic_auth and bearer_token, want an error")
+	}
+}
@@ -0,0 +1,13 @@
+package app
+
+import (
+	"github.com/fiffu/diffwatch/config"
+	"github.com/fiffu/diffwatch/lib/blobs"
+	"go.uber.org/zap"
+)
+
+// NewBlobStore returns the store that the raw responses of changed snapshots are archived in.
+func NewBlobStore(cfg *config.Config, log *zap.Logger) (*blobs.Store, error) {
+	log.Sugar().Infow("Archiving raw responses", "dir", cfg.ArchiveDir)
+	return blobs.NewStore(cfg.ArchiveDir)
+}
@@ -1,6 +1,7 @@
 package app
 
 import (
+	"github.com/fiffu/diffwatch/config"
 	"github.com/fiffu/diffwatch/lib/models"
 	"go.uber.org/fx"
 	"go.uber.org/zap"
@@ -8,8 +9,13 @@ import (
 	"gorm.io/gorm"
 )
 
-func NewDatabase(lc fx.Lifecycle, log *zap.Logger) *gorm.DB {
-	db, err := gorm.Open(sqlite.Open("diffwatch.sqlite"), &gorm.Config{})
+func NewDatabase(lc fx.Lifecycle, cfg *config.Config, log *zap.Logger) *gorm.DB {
+	if err := models.SetSecretKey(cfg.SecretKey); err != nil {
+		log.Sugar().Panic("invalid secret key", "err", err)
+	}
+
+	// WAL and the busy timeout let several worker processes share the database
+	db, err := gorm.Open(sqlite.Open("diffwatch.sqlite?_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate"), &gorm.Config{})
 	if err != nil {
 		log.Sugar().Panic("failed to connect database", "err", err)
 	}
@@ -20,10 +26,27 @@ func NewDatabase(lc fx.Lifecycle, log *zap.Logger) *gorm.DB {
 		&models.User{},
 		&models.Notifier{},
 		&models.NotifierConfirmation{},
+		&models.Target{},
 		&models.Subscription{},
 		&models.User{},
 		&models.Snapshot{},
-		&models.Chaser{},
+		&models.Job{},
+		&models.PendingNotification{},
+		&models.Setting{},
 	)
+
+	// Earlier versions marked subscriptions as having no content when content was found, which stops them being
+	// polled for good; clear the marks of subscriptions that have seen content since being marked
+	err = db.Exec(`
+		UPDATE subscriptions SET no_content_since = NULL
+		WHERE no_content_since IS NOT NULL AND EXISTS (
+			SELECT 1 FROM snapshots
+			WHERE snapshots.subscription_id = subscriptions.id
+			AND snapshots.content <> ''
+			AND snapshots.timestamp >= subscriptions.no_content_since
+		)`).Error
+	if err != nil {
+		log.Sugar().Panic("failed to clear no-content marks", "err", err)
+	}
 	return db
 }
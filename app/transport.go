@@ -1,21 +1,194 @@
 package app
 
 import (
+	"context"
+	"fmt"
+	"net"
 	"net/http"
+	"net/netip"
+	"strings"
+	"time"
 
+	"github.com/fiffu/diffwatch/config"
+	"github.com/fiffu/diffwatch/lib/proxies"
 	"go.uber.org/fx"
 	"go.uber.org/zap"
 )
 
-func NewTransport(lc fx.Lifecycle, log *zap.Logger) http.RoundTripper {
-	return http.DefaultTransport
+// NewTransport returns the transport for outbound requests, which refuses to connect to non-public addresses.
+func NewTransport(lc fx.Lifecycle, cfg *config.Config, log *zap.Logger) http.RoundTripper {
+	guard := newAddressGuard(cfg.Fetch.AllowedPrivateHosts, log)
+
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	base.DialContext = guard.DialContext
+	base.Proxy = proxies.FromRequest
+
+	return &transport{base, guard, log}
 }
 
 type transport struct {
-	base http.RoundTripper
-	log  *zap.Logger
+	base  http.RoundTripper
+	guard *addressGuard
+	log   *zap.Logger
 }
 
+// RoundTrip runs for every redirect, each of which is dialed, and so checked, through addressGuard.
 func (tpt *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch req.URL.Scheme {
+	case "http", "https":
+	default:
+		return nil, fmt.Errorf("unsupported URL scheme: %s", req.URL.Scheme)
+	}
+	if proxyURL, _ := proxies.FromRequest(req); proxyURL != nil {
+		// Only the proxy is dialed here, so the target is checked up front
+		if err := tpt.guard.check(req.Context(), req.URL.Hostname()); err != nil {
+			return nil, err
+		}
+	}
 	return tpt.base.RoundTrip(req)
 }
+
+// BlockedAddressError is returned when a request would connect to an address that is not publicly routable.
+type BlockedAddressError struct {
+	Host string
+	Addr netip.Addr
+}
+
+func (e *BlockedAddressError) Error() string {
+	return fmt.Sprintf("refusing to connect to %s: %s is not a public address", e.Host, e.Addr)
+}
+
+// addressGuard dials the IP it vetted, so that DNS can't point the host elsewhere after the check.
+type addressGuard struct {
+	dialer   *net.Dialer
+	resolver *net.Resolver
+	log      *zap.Logger
+
+	allowedHosts    map[string]bool
+	allowedPrefixes []netip.Prefix
+}
+
+func newAddressGuard(allowlist []string, log *zap.Logger) *addressGuard {
+	guard := &addressGuard{
+		dialer:       &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second},
+		resolver:     net.DefaultResolver,
+		log:          log,
+		allowedHosts: make(map[string]bool),
+	}
+	for _, entry := range allowlist {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			guard.allowedPrefixes = append(guard.allowedPrefixes, prefix.Masked())
+		} else if addr, err := netip.ParseAddr(entry); err == nil {
+			guard.allowedPrefixes = append(guard.allowedPrefixes, netip.PrefixFrom(addr, addr.BitLen()))
+		} else {
+			guard.allowedHosts[entry] = true
+		}
+	}
+	return guard
+}
+
+func (g *addressGuard) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if g.allowedHosts[strings.ToLower(host)] {
+		return g.dialer.DialContext(ctx, network, address)
+	}
+
+	addrs, err := g.resolve(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, addr := range addrs {
+		if !g.permits(addr) {
+			lastErr = &BlockedAddressError{host, addr}
+			g.log.Sugar().Warnw("Blocked outbound connection", "host", host, "addr", addr.String())
+			continue
+		}
+		conn, err := g.dialer.DialContext(ctx, network, net.JoinHostPort(addr.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// check refuses a host unless it is allowed or all of its addresses are.
+func (g *addressGuard) check(ctx context.Context, host string) error {
+	if g.allowedHosts[strings.ToLower(host)] {
+		return nil
+	}
+	addrs, err := g.resolve(ctx, host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if !g.permits(addr) {
+			g.log.Sugar().Warnw("Blocked proxied request", "host", host, "addr", addr.String())
+			return &BlockedAddressError{host, addr}
+		}
+	}
+	return nil
+}
+
+func (g *addressGuard) resolve(ctx context.Context, host string) ([]netip.Addr, error) {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{addr}, nil
+	}
+	addrs, err := g.resolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no addresses found for %s", host)
+	}
+	return addrs, nil
+}
+
+func (g *addressGuard) permits(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range g.allowedPrefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return isPublicAddr(addr)
+}
+
+// nonPublicPrefixes are non-public ranges that the netip predicates miss: "this network", CGNAT and NAT64.
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+func isPublicAddr(addr netip.Addr) bool {
+	switch {
+	case !addr.IsValid(),
+		addr.IsUnspecified(),
+		addr.IsLoopback(),
+		addr.IsPrivate(),
+		addr.IsLinkLocalUnicast(),
+		addr.IsLinkLocalMulticast(),
+		addr.IsInterfaceLocalMulticast(),
+		addr.IsMulticast():
+		return false
+	}
+	if addr.Is4() && addr == netip.AddrFrom4([4]byte{255, 255, 255, 255}) {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
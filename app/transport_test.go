@@ -0,0 +1,118 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"testing"
+
+	"github.com/fiffu/diffwatch/config"
+	"github.com/fiffu/diffwatch/lib/proxies"
+	"go.uber.org/zap"
+)
+
+func newTestTransport(allowlist ...string) http.RoundTripper {
+	cfg := &config.Config{}
+	cfg.Fetch.AllowedPrivateHosts = allowlist
+	return NewTransport(nil, cfg, zap.NewNop())
+}
+
+func get(t *testing.T, tpt http.RoundTripper, ctx context.Context, rawURL string) (string, error) {
+	t.Helper()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := tpt.RoundTrip(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	return string(body), err
+}
+
+func TestTransportBlocksNonPublicAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer srv.Close()
+
+	var blocked *BlockedAddressError
+	if _, err := get(t, newTestTransport(), context.Background(), srv.URL); !errors.As(err, &blocked) {
+		t.Errorf("got error %v, want a BlockedAddressError", err)
+	}
+	if body, err := get(t, newTestTransport("127.0.0.0/8"), context.Background(), srv.URL); err != nil || body != "ok" {
+		t.Errorf("got %q, %v from an allowed address, want \"ok\"", body, err)
+	}
+	if _, err := get(t, newTestTransport(), context.Background(), "file:///etc/passwd"); err == nil {
+		t.Error("fetched a file URL, want an error")
+	}
+}
+
+func TestTransportChecksTargetsBehindProxies(t *testing.T) {
+	// A forward proxy that answers for any target without connecting to it
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "proxied "+r.URL.Host)
+	}))
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+	proxyURL.Host = "localhost:" + proxyURL.Port()
+	ctx := proxies.WithProxy(context.Background(), proxyURL)
+
+	// The proxy itself is only reachable because it is allowed
+	tpt := newTestTransport("localhost", "10.1.2.3")
+
+	for _, target := range []string{"http://127.0.0.1/", "http://192.168.0.1/", "http://[::1]/", "http://169.254.169.254/"} {
+		var blocked *BlockedAddressError
+		if _, err := get(t, tpt, ctx, target); !errors.As(err, &blocked) {
+			t.Errorf("got error %v proxying to %s, want a BlockedAddressError", err, target)
+		}
+	}
+	if body, err := get(t, tpt, ctx, "http://10.1.2.3/"); err != nil || body != "proxied 10.1.2.3" {
+		t.Errorf("got %q, %v proxying to an allowed address, want it proxied", body, err)
+	}
+	if body, err := get(t, tpt, ctx, "http://93.184.215.14/"); err != nil || body != "proxied 93.184.215.14" {
+		t.Errorf("got %q, %v proxying to a public address, want it proxied", body, err)
+	}
+}
+
+func TestTransportIgnoresEnvironmentProxies(t *testing.T) {
+	t.Setenv("HTTP_PROXY", "http://proxy.example.com:3128")
+
+	base := newTestTransport().(*transport).base.(*http.Transport)
+	req, _ := http.NewRequest(http.MethodGet, "http://93.184.215.14/", nil)
+	if base.Proxy != nil {
+		if proxyURL, err := base.Proxy(req); proxyURL != nil || err != nil {
+			t.Errorf("Proxy() = %v, %v; want no proxy, even with one in the environment", proxyURL, err)
+		}
+	}
+}
+
+func TestIsPublicAddr(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"93.184.215.14":   true,
+		"2606:4700::1111": true,
+		"127.0.0.1":       false,
+		"10.0.0.1":        false,
+		"172.16.0.1":      false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"100.64.0.1":      false,
+		"0.0.0.0":         false,
+		"255.255.255.255": false,
+		"224.0.0.1":       false,
+		"::1":             false,
+		"fe80::1":         false,
+		"fc00::1":         false,
+		"64:ff9b::7f00:1": false,
+	} {
+		if got := isPublicAddr(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("isPublicAddr(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
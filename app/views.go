@@ -2,51 +2,192 @@ package app
 
 import (
 	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/fiffu/diffwatch/lib/models"
+	"github.com/fiffu/diffwatch/lib/snapshotter"
 )
 
 type SubscriptionView struct {
 	ID             uint         `json:"id"`
 	UserID         uint         `json:"user_id"`
+	TargetID       uint         `json:"target_id"`
 	Notifier       NotifierView `json:"notifier"`
 	Endpoint       string       `json:"endpoint"`
 	XPath          string       `json:"xpath"`
+	SelectorKind   string       `json:"selector_kind"`
+	MultiNode      bool         `json:"multi_node"`
+	ExtractMode    string       `json:"extract_mode"`
+	ExtractAttr    string       `json:"extract_attr,omitempty"`
+	Request        RequestView  `json:"request"`
+	Charset        string       `json:"charset,omitempty"`
+	Normalize      []string     `json:"normalize,omitempty"`
+	Rules          []string     `json:"rules,omitempty"`
+	ProxyProfile   string       `json:"proxy_profile,omitempty"`
+	HasProxyURL    bool         `json:"has_proxy_url"`
 	Title          string       `json:"title"`
+	TitleOverride  string       `json:"title_override,omitempty"`
 	ImageURL       string       `json:"image_url"`
+	Schedule       string       `json:"schedule,omitempty"`
+	Timezone       string       `json:"timezone,omitempty"`
+	MinInterval    string       `json:"min_interval,omitempty"`
+	MaxInterval    string       `json:"max_interval,omitempty"`
 	LastPollTime   *string      `json:"last_poll_time"`
+	NextPollAt     *string      `json:"next_poll_at"`
+	Interval       string       `json:"effective_interval,omitempty"`
+	IntervalReason string       `json:"interval_reason,omitempty"`
 	NoContentSince *string      `json:"no_content_since"`
+	LastError      string       `json:"last_error,omitempty"`
+	LastHTTPStatus int          `json:"last_http_status,omitempty"`
+	LastProxyError string       `json:"last_proxy_error,omitempty"`
+	Failures       int          `json:"consecutive_failures"`
+	PausedAt       *string      `json:"paused_at"`
 }
 
 type NotifierView struct {
-	Platform   string `json:"platform"`
-	Identifier string `json:"identifier"`
-	Verified   bool   `json:"verified"`
+	Platform     string  `json:"platform"`
+	Identifier   string  `json:"identifier"`
+	Verified     bool    `json:"verified"`
+	DeliveryMode string  `json:"delivery_mode"`
+	DigestTime   string  `json:"digest_time,omitempty"`
+	Timezone     string  `json:"timezone,omitempty"`
+	NextDigestAt *string `json:"next_digest_at"`
+}
+
+// RequestView shows how a subscription's endpoint is requested, with credentials and secret headers redacted.
+type RequestView struct {
+	Method     string            `json:"method"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	HasBody    bool              `json:"has_body"`
+	AuthScheme string            `json:"auth_scheme,omitempty"`
+}
+
+func (view RequestView) From(entity *models.RequestSpec) RequestView {
+	view = RequestView{
+		Method:     entity.Method,
+		HasBody:    entity.Body != "",
+		AuthScheme: entity.AuthScheme(),
+	}
+	if view.Method == "" {
+		view.Method = http.MethodGet
+	}
+	for name, values := range entity.Headers {
+		if view.Headers == nil {
+			view.Headers = make(map[string]string)
+		}
+		name = http.CanonicalHeaderKey(name)
+		if !models.IsSecretHeader(name) {
+			view.Headers[name] = strings.Join(values, ", ")
+		} else {
+			view.Headers[name] = "(redacted)"
+		}
+	}
+	return view
 }
 
 func (view NotifierView) From(entity *models.Notifier) NotifierView {
 	return NotifierView{
-		Platform:   entity.Platform,
-		Identifier: entity.PlatformIdentifier,
-		Verified:   entity.Verified,
+		Platform:     entity.Platform,
+		Identifier:   entity.PlatformIdentifier,
+		Verified:     entity.Verified,
+		DeliveryMode: string(entity.Delivery()),
+		DigestTime:   entity.DigestTime,
+		Timezone:     entity.Timezone,
+		NextDigestAt: ISOFormatSQLTime(entity.NextDigestAt),
 	}
 }
 
 func (view SubscriptionView) From(entity *models.Subscription) SubscriptionView {
+	kind, _ := entity.Selector()
+	mode, attr := entity.Extraction()
 	return SubscriptionView{
 		ID:             entity.ID,
 		UserID:         entity.UserID,
+		TargetID:       entity.TargetID,
 		Notifier:       NotifierView{}.From(&entity.Notifier),
 		Endpoint:       entity.Endpoint,
 		XPath:          entity.XPath,
-		Title:          entity.Title,
+		SelectorKind:   string(kind),
+		MultiNode:      entity.MultiNode,
+		ExtractMode:    string(mode),
+		ExtractAttr:    attr,
+		Request:        RequestView{}.From(&entity.Request),
+		Charset:        entity.Charset,
+		Normalize:      stringsOf(entity.Normalize),
+		Rules:          stringsOf(entity.Rules),
+		ProxyProfile:   entity.ProxyProfile,
+		HasProxyURL:    entity.ProxyURL != "",
+		Title:          entity.DisplayTitle(),
+		TitleOverride:  entity.TitleOverride,
 		ImageURL:       entity.ImageURL,
+		Schedule:       entity.Schedule,
+		Timezone:       entity.Timezone,
+		MinInterval:    formatDuration(entity.MinInterval),
+		MaxInterval:    formatDuration(entity.MaxInterval),
 		LastPollTime:   ISOFormatSQLTime(entity.LastPollTime),
+		NextPollAt:     ISOFormatSQLTime(entity.NextPollAt),
+		Interval:       formatDuration(entity.EffectiveInterval),
+		IntervalReason: entity.IntervalReason,
 		NoContentSince: ISOFormatSQLTime(entity.NoContentSince),
+		LastError:      entity.LastError,
+		LastHTTPStatus: entity.LastHTTPStatus,
+		LastProxyError: entity.LastProxyError,
+		Failures:       entity.ConsecutiveFailures,
+		PausedAt:       ISOFormatSQLTime(entity.PausedAt),
+	}
+}
+
+// SnapshotView shows a snapshot in a subscription's history, where reverted ones weren't notified.
+type SnapshotView struct {
+	CapturedAt    *string            `json:"captured_at"`
+	LastSeenAt    *string            `json:"last_seen_at"`
+	Content       string             `json:"content"`
+	Items         models.Items       `json:"items,omitempty"`
+	Entries       models.FeedEntries `json:"entries,omitempty"`
+	FiredRule     string             `json:"fired_rule,omitempty"`
+	Reverted      bool               `json:"reverted"`
+	ContentDigest string             `json:"content_digest"`
+	ArchiveDigest string             `json:"archive_digest,omitempty"`
+}
+
+func (view SnapshotView) From(entity *models.Snapshot) SnapshotView {
+	return SnapshotView{
+		CapturedAt:    ISOFormatSQLTime(entity.CapturedAt),
+		LastSeenAt:    ISOFormatTime(entity.Timestamp),
+		Content:       entity.Content,
+		Items:         entity.Items,
+		Entries:       entity.Entries,
+		FiredRule:     entity.FiredRule,
+		Reverted:      entity.Reverted,
+		ContentDigest: entity.ContentDigest,
+		ArchiveDigest: entity.RawBodyDigest,
 	}
 }
 
+// IntervalsView shows the snapshotter's intervals as Go durations, e.g. "1h0m0s".
+type IntervalsView struct {
+	PollInterval  string `json:"poll_interval"`
+	ChaseInterval string `json:"chase_interval"`
+}
+
+func (view IntervalsView) From(entity snapshotter.Intervals) IntervalsView {
+	return IntervalsView{
+		PollInterval:  entity.Poll.String(),
+		ChaseInterval: entity.Chase.String(),
+	}
+}
+
+func stringsOf[T fmt.Stringer](elems []T) []string {
+	var out []string
+	for _, elem := range elems {
+		out = append(out, elem.String())
+	}
+	return out
+}
+
 type Fromable[Entity any, Repr any] interface {
 	From(Entity) Repr
 }
@@ -68,6 +209,14 @@ func ISOFormatSQLTime(t sql.NullTime) *string {
 	return &s
 }
 
+// formatDuration formats a duration as Go does, e.g. "1h30m0s", or as empty if it is zero.
+func formatDuration(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.String()
+}
+
 func ISOFormatTime(t time.Time) *string {
 	if t.IsZero() {
 		return nil
@@ -3,12 +3,13 @@ module github.com/fiffu/diffwatch
 go 1.22.5
 
 require (
+	github.com/andybalholm/cascadia v1.3.3
 	github.com/antchfx/htmlquery v1.3.2
 	github.com/caarlos0/env/v11 v11.2.2
 	github.com/carlmjohnson/requests v0.24.2
 	github.com/go-chi/chi/v5 v5.1.0
 	github.com/mailgun/mailgun-go/v4 v4.15.2
-	golang.org/x/net v0.27.0
+	golang.org/x/net v0.33.0
 )
 
 require (
@@ -29,8 +30,8 @@ require (
 	go.uber.org/fx v1.22.2
 	go.uber.org/multierr v1.10.0 // indirect
 	go.uber.org/zap v1.26.0
-	golang.org/x/sys v0.22.0 // indirect
-	golang.org/x/text v0.18.0 // indirect
+	golang.org/x/sys v0.28.0 // indirect
+	golang.org/x/text v0.21.0
 	gorm.io/driver/sqlite v1.5.6
 	gorm.io/gorm v1.25.12
 )
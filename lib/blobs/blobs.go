@@ -0,0 +1,132 @@
+// Package blobs stores gzipped blobs on local disk, named by the SHA-256 digest of their content.
+package blobs
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ErrInvalidDigest is returned for digests that aren't hex-encoded SHA-256 digests.
+var ErrInvalidDigest = errors.New("invalid blob digest")
+
+var digestPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+const (
+	blobExt    = ".gz"
+	tempPrefix = ".tmp-"
+)
+
+type Store struct {
+	dir string
+}
+
+// NewStore returns a store keeping its blobs under dir, which is created if it doesn't exist.
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	return &Store{dir}, nil
+}
+
+// Digest returns the name that data is stored under.
+func Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Put stores data and returns its digest, refreshing the write time of a blob already stored.
+func (s *Store) Put(data []byte) (string, error) {
+	digest := Digest(data)
+	path := s.path(digest)
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err == nil {
+		return digest, nil
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return "", err
+	}
+	// Write to a temporary file first, so that a blob is never seen half-written
+	tmp, err := os.CreateTemp(dir, tempPrefix+"*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	zw := gzip.NewWriter(tmp)
+	_, err = zw.Write(data)
+	err = errors.Join(err, zw.Close(), tmp.Close())
+	if err != nil {
+		return "", fmt.Errorf("unable to write blob %s: %w", digest, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", err
+	}
+	return digest, nil
+}
+
+// Open returns a reader of the blob's content, or an fs.ErrNotExist error if there is none.
+func (s *Store) Open(digest string) (io.ReadCloser, error) {
+	if !digestPattern.MatchString(digest) {
+		return nil, ErrInvalidDigest
+	}
+	f, err := os.Open(s.path(digest))
+	if err != nil {
+		return nil, err
+	}
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("unable to read blob %s: %w", digest, err)
+	}
+	return &blobReader{zr, f}, nil
+}
+
+// Sweep removes unreferenced blobs written before the cutoff, and leftovers of failed writes.
+func (s *Store) Sweep(referenced map[string]bool, cutoff time.Time) (removed int, err error) {
+	err = filepath.WalkDir(s.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		name := entry.Name()
+		digest, isBlob := strings.CutSuffix(name, blobExt)
+		if !strings.HasPrefix(name, tempPrefix) && (!isBlob || referenced[digest]) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		removed++
+		return nil
+	})
+	return removed, err
+}
+
+// path spreads the blobs over subdirectories by the first byte of their digest, to keep directories small.
+func (s *Store) path(digest string) string {
+	return filepath.Join(s.dir, digest[:2], digest+blobExt)
+}
+
+type blobReader struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (r *blobReader) Close() error {
+	return errors.Join(r.Reader.Close(), r.file.Close())
+}
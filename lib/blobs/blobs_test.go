@@ -0,0 +1,99 @@
+package blobs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func readBlob(t *testing.T, s *Store, digest string) ([]byte, error) {
+	r, err := s.Open(digest)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func TestStorePutOpen(t *testing.T) {
+	s, err := NewStore(filepath.Join(t.TempDir(), "blobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("<html>hello</html>")
+	digest, err := s.Put(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != Digest(data) {
+		t.Errorf("Put() = %s, want the digest of the data %s", digest, Digest(data))
+	}
+	if again, err := s.Put(data); err != nil || again != digest {
+		t.Errorf("Put() of the same data = %s, %v; want %s", again, err, digest)
+	}
+	if got, err := readBlob(t, s, digest); err != nil || string(got) != string(data) {
+		t.Errorf("Open() read %q, %v; want %q", got, err, data)
+	}
+
+	if _, err := s.Open(Digest([]byte("other"))); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Open() of a missing blob returned %v, want fs.ErrNotExist", err)
+	}
+	for _, digest := range []string{"", "../../etc/passwd", digest[:63], digest + "0"} {
+		if _, err := s.Open(digest); !errors.Is(err, ErrInvalidDigest) {
+			t.Errorf("Open(%q) returned %v, want ErrInvalidDigest", digest, err)
+		}
+	}
+}
+
+func TestStoreSweep(t *testing.T) {
+	s, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	cutoff := time.Now().Add(-time.Hour)
+	age := func(path string) {
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	kept, _ := s.Put([]byte("referenced"))
+	dropped, _ := s.Put([]byte("unreferenced"))
+	recent, _ := s.Put([]byte("recent"))
+	refreshed, _ := s.Put([]byte("refreshed"))
+	age(s.path(kept))
+	age(s.path(dropped))
+	age(s.path(refreshed))
+	// Putting a blob again keeps it from being swept until the cutoff passes it
+	if _, err := s.Put([]byte("refreshed")); err != nil {
+		t.Fatal(err)
+	}
+
+	tmp := filepath.Join(s.dir, kept[:2], tempPrefix+"leftover")
+	if err := os.WriteFile(tmp, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	age(tmp)
+
+	removed, err := s.Sweep(map[string]bool{kept: true}, cutoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 2 {
+		t.Errorf("Sweep() removed %d files, want 2", removed)
+	}
+	for digest, want := range map[string]bool{kept: true, dropped: false, recent: true, refreshed: true} {
+		if _, err := readBlob(t, s, digest); (err == nil) != want {
+			t.Errorf("blob %s kept = %v, want %v", digest, err == nil, want)
+		}
+	}
+	if _, err := os.Stat(tmp); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("leftover of a failed write wasn't swept: %v", err)
+	}
+}
@@ -2,8 +2,10 @@ package lib
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
+	"regexp"
 	"time"
 
 	"github.com/fiffu/diffwatch/config"
@@ -21,7 +23,32 @@ type subscribe struct {
 	snaps *snapshotter.Snapshotter
 }
 
-func (svc *subscribe) CreateSubscription(ctx context.Context, userID uint, endpoint, xpath string) (*models.Snapshot, *models.Subscription, error) {
+// SubscriptionParams describes the endpoint and selector that a new subscription should watch.
+type SubscriptionParams struct {
+	Endpoint     string
+	SelectorKind models.SelectorKind
+	Selector     string
+	MultiNode    bool
+	ExtractMode  models.ExtractMode
+	ExtractAttr  string
+	Request      models.RequestSpec
+	Charset      string
+	Normalize    models.NormalizeSteps
+	ProxyProfile string
+	ProxyURL     string
+
+	// Per-subscriber settings, which don't affect what is fetched
+	Title    string // Overrides the title found on the page
+	Rules    models.Rules
+	Schedule string // Interval or cron expression; empty polls at the default interval
+	Timezone string // Time zone of a cron schedule
+
+	// Bounds of the adaptive interval for subscriptions without a schedule; zero uses the default
+	MinInterval time.Duration
+	MaxInterval time.Duration
+}
+
+func (svc *subscribe) CreateSubscription(ctx context.Context, userID uint, params SubscriptionParams) (*models.Snapshot, *models.Subscription, error) {
 	notifier := models.Notifier{}
 	tx := svc.db.Where("user_id = ?", userID).First(&notifier)
 	if err := tx.Error; err != nil {
@@ -32,17 +59,22 @@ func (svc *subscribe) CreateSubscription(ctx context.Context, userID uint, endpo
 		return nil, nil, errors.New("unable to find verified notifier")
 	}
 
-	sub, content, err := svc.subscribeIfValidEndpoint(ctx, userID, notifier.ID, endpoint, xpath)
+	sub, content, err := svc.subscribeIfValidEndpoint(ctx, userID, notifier.ID, params)
 	if err != nil {
 		return nil, nil, err
 	}
 
+	now := time.Now().UTC()
 	snap := models.Snapshot{
-		Timestamp:      time.Now().UTC(),
+		Timestamp:      now,
+		CapturedAt:     sql.NullTime{Time: now, Valid: true},
 		UserID:         userID,
 		SubscriptionID: sub.ID,
 		Content:        content.Text,
+		Items:          content.Items,
+		Entries:        content.Entries,
 	}
+	svc.snaps.ArchiveRaw(&snap, content.Raw)
 	tx = svc.db.Create(&snap)
 	if err := tx.Error; err != nil {
 		return nil, nil, err
@@ -51,26 +83,97 @@ func (svc *subscribe) CreateSubscription(ctx context.Context, userID uint, endpo
 	return &snap, sub, nil
 }
 
-func (svc *subscribe) subscribeIfValidEndpoint(ctx context.Context, userID, notifierID uint, endpoint, xpath string) (*models.Subscription, *models.EndpointContent, error) {
-	content, err := svc.snaps.GetEndpointContent(ctx, endpoint, xpath)
+func (svc *subscribe) subscribeIfValidEndpoint(ctx context.Context, userID, notifierID uint, params SubscriptionParams) (*models.Subscription, *models.EndpointContent, error) {
+	spec := models.WatchSpec{
+		Endpoint:     params.Endpoint,
+		XPath:        params.Selector,
+		SelectorKind: params.SelectorKind,
+		MultiNode:    params.MultiNode,
+		ExtractMode:  params.ExtractMode,
+		ExtractAttr:  params.ExtractAttr,
+		Request:      params.Request,
+		Charset:      params.Charset,
+		Normalize:    params.Normalize,
+		ProxyProfile: params.ProxyProfile,
+		ProxyURL:     models.SealedString(params.ProxyURL),
+	}
+	kind, expr := spec.Selector()
+	if expr == "" && kind != models.SelectorFeed {
+		return nil, nil, fmt.Errorf("%s is required", kind)
+	}
+	mode, attr := spec.Extraction()
+	if err := validateExtraction(kind, expr, mode, attr); err != nil {
+		return nil, nil, err
+	}
+
+	// Fetch without any cache validators, as the subscription's first snapshot needs the full content
+	candidate := &models.Target{WatchSpec: spec}
+	content, err := svc.snaps.GetEndpointContent(ctx, candidate, models.CacheValidators{})
 	if err != nil {
 		return nil, nil, err
 	}
-	if content.Text == "" {
-		return nil, nil, fmt.Errorf("no result extracted from %s using xpath: %s", endpoint, xpath)
+	switch {
+	case content.Text == "" && kind == models.SelectorFeed:
+		return nil, nil, fmt.Errorf("no entries found in feed at %s", params.Endpoint)
+	case content.Text == "" && mode == models.ExtractAttribute:
+		return nil, nil, fmt.Errorf("no attribute %s extracted from %s using %s: %s", attr, params.Endpoint, kind, expr)
+	case content.Text == "":
+		return nil, nil, fmt.Errorf("no result extracted from %s using %s: %s", params.Endpoint, kind, expr)
+	}
+
+	target, err := svc.snaps.SaveTarget(ctx, candidate)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	sub := &models.Subscription{
-		UserID:     userID,
-		NotifierID: notifierID,
-		Endpoint:   endpoint,
-		XPath:      xpath,
-		Title:      content.Title,
-		ImageURL:   content.ImageURL,
+		UserID:        userID,
+		NotifierID:    notifierID,
+		TargetID:      target.ID,
+		WatchSpec:     spec,
+		TitleOverride: params.Title,
+		Rules:         params.Rules,
+		Schedule:      params.Schedule,
+		Timezone:      params.Timezone,
+		MinInterval:   params.MinInterval,
+		MaxInterval:   params.MaxInterval,
+		Title:         content.Title,
+		ImageURL:      content.ImageURL,
+	}
+	sub.CacheValidators = content.CacheValidators
+	plan, err := svc.snaps.PlanNextPoll(ctx, sub, time.Now().UTC())
+	if err != nil {
+		return nil, nil, err
 	}
+	sub.NextPollAt = sql.NullTime{Time: plan.At, Valid: true}
+	sub.EffectiveInterval = plan.Interval
+	sub.IntervalReason = plan.Reason
+
 	tx := svc.db.Clauses(clause.OnConflict{DoNothing: true}).Create(sub)
 	if err := tx.Error; err != nil {
 		return nil, nil, err
 	}
 	return sub, content, nil
 }
+
+// xpathAttributeStep matches XPaths that select an attribute themselves, e.g. //a/@href.
+var xpathAttributeStep = regexp.MustCompile(`/@[\w:-]+\s*$`)
+
+// validateExtraction checks that the extraction mode can be used with the selector.
+func validateExtraction(kind models.SelectorKind, expr string, mode models.ExtractMode, attr string) error {
+	switch mode {
+	case models.ExtractText:
+		return nil
+	case models.ExtractAttribute, models.ExtractOuterHTML, models.ExtractInnerHTML:
+	default:
+		return fmt.Errorf("unknown extraction mode %q, expected one of text, attribute, outer_html or inner_html", mode)
+	}
+
+	if kind != models.SelectorXPath && kind != models.SelectorCSS {
+		return fmt.Errorf("%s extraction is only supported with xpath and css selectors", mode)
+	}
+	if mode == models.ExtractAttribute && attr == "" && !(kind == models.SelectorXPath && xpathAttributeStep.MatchString(expr)) {
+		return errors.New("attribute extraction requires attr, e.g. attr=href, unless the xpath ends in an attribute such as /@href")
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+// Package dbtypes has column types shared by the models.
+package dbtypes
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// JSONColumn stores the value it points to as JSON text, for types stored as JSON to implement their column methods.
+type JSONColumn[T any] struct {
+	ptr *T
+}
+
+// JSON returns the column holding *v.
+func JSON[T any](v *T) JSONColumn[T] {
+	return JSONColumn[T]{v}
+}
+
+func (c JSONColumn[T]) GormDataType() string {
+	return "text"
+}
+
+// Value marshals the value, or returns NULL for a nil slice, map or pointer.
+func (c JSONColumn[T]) Value() (driver.Value, error) {
+	switch v := reflect.ValueOf(*c.ptr); v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Pointer:
+		if v.IsNil() {
+			return nil, nil
+		}
+	}
+	b, err := json.Marshal(*c.ptr)
+	return string(b), err
+}
+
+// Scan unmarshals the value, or sets it to its zero value from NULL.
+func (c JSONColumn[T]) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		var zero T
+		*c.ptr = zero
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), c.ptr)
+	case []byte:
+		return json.Unmarshal(v, c.ptr)
+	}
+	return fmt.Errorf("unable to scan %T into %T", src, *c.ptr)
+}
@@ -0,0 +1,318 @@
+// Package diff computes Myers line diffs, highlighting the words that changed within lines.
+package diff
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/fiffu/diffwatch/lib/dbtypes"
+)
+
+// Op is what a line or segment of a diff does to the old text.
+type Op string
+
+const (
+	Equal  Op = "equal"
+	Insert Op = "insert"
+	Delete Op = "delete"
+)
+
+// Segment is a run of words within a changed line that were kept, inserted or deleted.
+type Segment struct {
+	Op   Op     `json:"op"`
+	Text string `json:"text"`
+}
+
+// Line is a line of a hunk, with word-level segments if it was deleted or inserted in place of another.
+type Line struct {
+	Op       Op        `json:"op"`
+	Text     string    `json:"text"`
+	Segments []Segment `json:"segments,omitempty"`
+}
+
+// Hunk is a run of changed lines with some context, from 1-based starting line numbers.
+type Hunk struct {
+	OldStart int    `json:"old_start"`
+	OldLines int    `json:"old_lines"`
+	NewStart int    `json:"new_start"`
+	NewLines int    `json:"new_lines"`
+	Lines    []Line `json:"lines"`
+}
+
+// Header returns the hunk's range in unified diff notation, e.g. "@@ -3,7 +3,8 @@".
+func (h Hunk) Header() string {
+	return fmt.Sprintf("@@ -%d,%d +%d,%d @@", h.OldStart, h.OldLines, h.NewStart, h.NewLines)
+}
+
+// Hunks is a diff, stored as a JSON array.
+type Hunks []Hunk
+
+func (hunks Hunks) GormDataType() string {
+	return dbtypes.JSON(&hunks).GormDataType()
+}
+
+func (hunks Hunks) Value() (driver.Value, error) {
+	return dbtypes.JSON(&hunks).Value()
+}
+
+func (hunks *Hunks) Scan(src any) error {
+	return dbtypes.JSON(hunks).Scan(src)
+}
+
+// Unified renders the diff in unified diff format, without file headers.
+func (hunks Hunks) Unified() string {
+	var b strings.Builder
+	for _, hunk := range hunks {
+		b.WriteString(hunk.Header())
+		b.WriteByte('\n')
+		for _, line := range hunk.Lines {
+			switch line.Op {
+			case Insert:
+				b.WriteByte('+')
+			case Delete:
+				b.WriteByte('-')
+			default:
+				b.WriteByte(' ')
+			}
+			b.WriteString(line.Text)
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
+
+// ContextLines is how many unchanged lines are kept around each change.
+const ContextLines = 3
+
+// Compute diffs the old and new text line by line, returning nil if they are the same.
+func Compute(old, new string) Hunks {
+	a, b := splitLines(old), splitLines(new)
+	edits := myers(a, b)
+
+	// Find the changes, and cut the edit script into hunks wherever they are far enough apart
+	var hunks Hunks
+	var oldLine, newLine int
+	for start := 0; start < len(edits); {
+		if edits[start] == Equal {
+			oldLine, newLine = oldLine+1, newLine+1
+			start++
+			continue
+		}
+
+		from := max(start-ContextLines, 0)
+		end := start
+		for end < len(edits) {
+			if edits[end] != Equal {
+				end++
+				continue
+			}
+			run := end
+			for run < len(edits) && edits[run] == Equal {
+				run++
+			}
+			if run == len(edits) || run-end > 2*ContextLines {
+				break
+			}
+			end = run
+		}
+		to := min(end+ContextLines, len(edits))
+
+		hunk := Hunk{
+			OldStart: oldLine - (start - from) + 1,
+			NewStart: newLine - (start - from) + 1,
+		}
+		i, j := oldLine-(start-from), newLine-(start-from)
+		for _, op := range edits[from:to] {
+			switch op {
+			case Equal:
+				hunk.Lines = append(hunk.Lines, Line{Op: Equal, Text: a[i]})
+				i, j = i+1, j+1
+			case Delete:
+				hunk.Lines = append(hunk.Lines, Line{Op: Delete, Text: a[i]})
+				i++
+			case Insert:
+				hunk.Lines = append(hunk.Lines, Line{Op: Insert, Text: b[j]})
+				j++
+			}
+		}
+		hunk.OldLines = i - (hunk.OldStart - 1)
+		hunk.NewLines = j - (hunk.NewStart - 1)
+		if hunk.OldLines == 0 {
+			hunk.OldStart-- // By convention, an empty range starts at the line before it
+		}
+		if hunk.NewLines == 0 {
+			hunk.NewStart--
+		}
+		highlightWords(hunk.Lines)
+		hunks = append(hunks, hunk)
+
+		oldLine, newLine = i, j
+		start = to
+	}
+	return hunks
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+var words = regexp.MustCompile(`\s+|\w+|[^\w\s]`)
+
+// highlightWords diffs each run of deleted lines word by word against the inserted lines after it.
+func highlightWords(lines []Line) {
+	for i := 0; i < len(lines); {
+		if lines[i].Op != Delete {
+			i++
+			continue
+		}
+		dels := i
+		for i < len(lines) && lines[i].Op == Delete {
+			i++
+		}
+		ins := i
+		for i < len(lines) && lines[i].Op == Insert {
+			i++
+		}
+		for k := 0; dels+k < ins && ins+k < i; k++ {
+			lines[dels+k].Segments, lines[ins+k].Segments = diffWords(lines[dels+k].Text, lines[ins+k].Text)
+		}
+	}
+}
+
+// diffWords returns the kept and deleted segments of the old line, and the kept and inserted ones of the new.
+func diffWords(old, new string) (oldSegs, newSegs []Segment) {
+	a, b := words.FindAllString(old, -1), words.FindAllString(new, -1)
+	var i, j int
+	for _, op := range myers(a, b) {
+		switch op {
+		case Equal:
+			oldSegs = appendSegment(oldSegs, Equal, a[i])
+			newSegs = appendSegment(newSegs, Equal, b[j])
+			i, j = i+1, j+1
+		case Delete:
+			oldSegs = appendSegment(oldSegs, Delete, a[i])
+			i++
+		case Insert:
+			newSegs = appendSegment(newSegs, Insert, b[j])
+			j++
+		}
+	}
+	return
+}
+
+func appendSegment(segs []Segment, op Op, text string) []Segment {
+	if n := len(segs); n > 0 && segs[n-1].Op == op {
+		segs[n-1].Text += text
+		return segs
+	}
+	return append(segs, Segment{op, text})
+}
+
+// maxTrace bounds the memory of a diff, beyond which the differing middle is reported as replaced.
+const maxTrace = 1 << 21
+
+// myers returns the shortest edit script that turns a into b, as one op per line kept, deleted or inserted.
+func myers[T comparable](a, b []T) []Op {
+	// Strip the common prefix and suffix, which is usually most of it
+	var prefix, suffix int
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := make([]Op, 0, len(a)+len(b))
+	for range prefix {
+		ops = append(ops, Equal)
+	}
+	ops = append(ops, myersMiddle(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for range suffix {
+		ops = append(ops, Equal)
+	}
+	return ops
+}
+
+func myersMiddle[T comparable](a, b []T) []Op {
+	n, m := len(a), len(b)
+	maxD := n + m
+	offset := maxD + 1
+	v := make([]int, 2*maxD+3)
+
+	var trace [][]int
+	for d := 0; d <= maxD; d++ {
+		if len(trace)*len(v) > maxTrace {
+			return replaceAll(n, m)
+		}
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1] // Down: insert from b
+			} else {
+				x = v[offset+k-1] + 1 // Right: delete from a
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, offset, n, m)
+			}
+		}
+	}
+	return replaceAll(n, m)
+}
+
+// backtrack walks the trace of furthest reaching paths back from the end, collecting the edits on the way.
+func backtrack(trace [][]int, offset, x, y int) []Op {
+	var ops []Op
+	for d := len(trace) - 1; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			ops = append(ops, Equal)
+			x, y = x-1, y-1
+		}
+		if x == prevX {
+			ops = append(ops, Insert)
+		} else {
+			ops = append(ops, Delete)
+		}
+		x, y = prevX, prevY
+	}
+	for x > 0 && y > 0 {
+		ops = append(ops, Equal)
+		x, y = x-1, y-1
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+func replaceAll(n, m int) []Op {
+	ops := make([]Op, 0, n+m)
+	for range n {
+		ops = append(ops, Delete)
+	}
+	for range m {
+		ops = append(ops, Insert)
+	}
+	return ops
+}
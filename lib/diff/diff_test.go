@@ -0,0 +1,147 @@
+package diff
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestComputeUnified(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		want     string
+	}{
+		{"same", "a\nb\n", "a\nb", ""},
+		{"added to empty", "", "a\nb", "@@ -0,0 +1,2 @@\n+a\n+b\n"},
+		{"emptied", "a\nb", "", "@@ -1,2 +0,0 @@\n-a\n-b\n"},
+		{"changed line", "a\nb\nc", "a\nB\nc", "@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n"},
+		{
+			"context is limited",
+			"1\n2\n3\n4\n5\n6\n7\n8\n9",
+			"1\n2\n3\n4\nfive\n6\n7\n8\n9",
+			"@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			"far apart changes are separate hunks",
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12",
+			"one\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ntwelve",
+			"@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+twelve\n",
+		},
+		{
+			"close changes share a hunk",
+			"1\n2\n3\n4\n5\n6\n7\n8",
+			"one\n2\n3\n4\n5\n6\n7\neight",
+			"@@ -1,8 +1,8 @@\n-1\n+one\n 2\n 3\n 4\n 5\n 6\n 7\n-8\n+eight\n",
+		},
+		{"inserted in the middle", "a\nc", "a\nb\nc", "@@ -1,2 +1,3 @@\n a\n+b\n c\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hunks := Compute(tt.old, tt.new)
+			if got := hunks.Unified(); got != tt.want {
+				t.Errorf("Compute().Unified() =\n%s\nwant\n%s", got, tt.want)
+			}
+			if tt.want == "" && hunks != nil {
+				t.Errorf("Compute() = %v, want nil for the same text", hunks)
+			}
+		})
+	}
+}
+
+func TestComputeHighlightsWords(t *testing.T) {
+	hunks := Compute("Price: $10 today", "Price: $12 today")
+	if len(hunks) != 1 || len(hunks[0].Lines) != 2 {
+		t.Fatalf("Compute() = %+v, want one hunk of two lines", hunks)
+	}
+	wantOld := []Segment{{Equal, "Price: $"}, {Delete, "10"}, {Equal, " today"}}
+	wantNew := []Segment{{Equal, "Price: $"}, {Insert, "12"}, {Equal, " today"}}
+	if got := hunks[0].Lines[0].Segments; !reflect.DeepEqual(got, wantOld) {
+		t.Errorf("deleted line segments = %+v, want %+v", got, wantOld)
+	}
+	if got := hunks[0].Lines[1].Segments; !reflect.DeepEqual(got, wantNew) {
+		t.Errorf("inserted line segments = %+v, want %+v", got, wantNew)
+	}
+
+	// Lines that were only inserted have nothing to be highlighted against
+	if got := Compute("a", "a\nb")[0].Lines[1].Segments; got != nil {
+		t.Errorf("inserted line segments = %+v, want none", got)
+	}
+}
+
+// apply rebuilds the new text from the old one and the hunks.
+func apply(old string, hunks Hunks) string {
+	a := splitLines(old)
+	var out []string
+	next := 0
+	for _, h := range hunks {
+		start := h.OldStart - 1
+		if h.OldLines == 0 {
+			start = h.OldStart
+		}
+		out = append(out, a[next:start]...)
+		for _, line := range h.Lines {
+			if line.Op != Delete {
+				out = append(out, line.Text)
+			}
+		}
+		next = start + h.OldLines
+	}
+	return strings.Join(append(out, a[next:]...), "\n")
+}
+
+func TestComputeRebuildsNewText(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	randomText := func(n int) string {
+		lines := make([]string, n)
+		for i := range lines {
+			lines[i] = string(rune('a' + rng.Intn(4)))
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	for i := 0; i < 500; i++ {
+		old, new := randomText(rng.Intn(30)), randomText(rng.Intn(30))
+		if got := apply(old, Compute(old, new)); got != new {
+			t.Fatalf("applying the diff of %q to %q gave %q", old, new, got)
+		}
+	}
+
+	// Past maxTrace, the differing middle is replaced wholesale
+	old, new := randomText(3000), randomText(3000)
+	if got := apply(old, Compute(old, new)); got != new {
+		t.Error("applying a diff too large to trace didn't rebuild the new text")
+	}
+}
+
+func TestMyersIsMinimal(t *testing.T) {
+	edits := func(a, b string) int {
+		n := 0
+		for _, op := range myers(strings.Split(a, ""), strings.Split(b, "")) {
+			if op != Equal {
+				n++
+			}
+		}
+		return n
+	}
+	// The example from Myers' paper
+	if got := edits("abcabba", "cbabac"); got != 5 {
+		t.Errorf("myers() made %d edits, want 5", got)
+	}
+	if got := edits("kitten", "sitting"); got != 5 {
+		t.Errorf("myers() made %d edits, want 5", got)
+	}
+}
+
+func TestHunksRoundTrip(t *testing.T) {
+	hunks := Compute("a\nb", "a\nc")
+	v, err := hunks.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Hunks
+	if err := got.Scan(v); err != nil || !reflect.DeepEqual(got, hunks) {
+		t.Errorf("Scan(Value()) = %+v, %v; want %+v", got, err, hunks)
+	}
+}
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+
+	"github.com/fiffu/diffwatch/lib/dbtypes"
+)
+
+// FeedEntry is an RSS or Atom entry, identified by its GUID or link, whose Digest changes when it is edited.
+type FeedEntry struct {
+	ID     string `json:"id"`
+	Title  string `json:"title"`
+	Link   string `json:"link"`
+	Digest string `json:"digest"`
+}
+
+func (e FeedEntry) String() string {
+	switch {
+	case e.Link == "":
+		return e.Title
+	case e.Title == "":
+		return e.Link
+	}
+	return fmt.Sprintf("%s <%s>", e.Title, e.Link)
+}
+
+// FeedEntries is the list of entries in a feed, in feed order, stored as a JSON array.
+type FeedEntries []FeedEntry
+
+func (entries FeedEntries) GormDataType() string {
+	return dbtypes.JSON(&entries).GormDataType()
+}
+
+func (entries FeedEntries) Value() (driver.Value, error) {
+	return dbtypes.JSON(&entries).Value()
+}
+
+func (entries *FeedEntries) Scan(src any) error {
+	return dbtypes.JSON(entries).Scan(src)
+}
+
+// Digest fingerprints the entries by their IDs and digests, so that an edit to any entry changes it.
+func (entries FeedEntries) Digest() string {
+	lines := make([]string, len(entries))
+	for i, entry := range entries {
+		lines[i] = entry.ID + " " + entry.Digest
+	}
+	return DigestContent(strings.Join(lines, "\n"))
+}
+
+// DiffFeedEntries returns the entries of curr that are new or edited since prev; dropped entries aren't reported.
+func DiffFeedEntries(prev, curr FeedEntries) (added, edited FeedEntries) {
+	digests := make(map[string]string, len(prev))
+	for _, entry := range prev {
+		digests[entry.ID] = entry.Digest
+	}
+	for _, entry := range curr {
+		digest, ok := digests[entry.ID]
+		switch {
+		case !ok:
+			added = append(added, entry)
+		case digest != entry.Digest:
+			edited = append(edited, entry)
+		}
+	}
+	return
+}
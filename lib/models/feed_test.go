@@ -0,0 +1,38 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffFeedEntries(t *testing.T) {
+	prev := FeedEntries{{ID: "1", Digest: "a"}, {ID: "2", Digest: "b"}, {ID: "3", Digest: "c"}}
+	curr := FeedEntries{{ID: "4", Digest: "d"}, {ID: "1", Digest: "a"}, {ID: "2", Digest: "B"}}
+
+	added, edited := DiffFeedEntries(prev, curr)
+	if want := (FeedEntries{{ID: "4", Digest: "d"}}); !reflect.DeepEqual(added, want) {
+		t.Errorf("added = %+v, want %+v", added, want)
+	}
+	if want := (FeedEntries{{ID: "2", Digest: "B"}}); !reflect.DeepEqual(edited, want) {
+		t.Errorf("edited = %+v, want %+v", edited, want)
+	}
+	if curr.Digest() == prev.Digest() || curr.Digest() != append(FeedEntries{}, curr...).Digest() {
+		t.Error("Digest() doesn't follow the entries")
+	}
+}
+
+func TestFeedEntriesRoundTrip(t *testing.T) {
+	entries := FeedEntries{{ID: "1", Title: "One", Link: "https://example.com/1", Digest: "a"}}
+	v, err := entries.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got FeedEntries
+	if err := got.Scan(v); err != nil || !reflect.DeepEqual(got, entries) {
+		t.Errorf("Scan(Value()) = %+v, %v; want %+v", got, err, entries)
+	}
+
+	if v, err := FeedEntries(nil).Value(); v != nil || err != nil {
+		t.Errorf("nil entries are stored as %v, %v; want NULL", v, err)
+	}
+}
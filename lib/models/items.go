@@ -0,0 +1,44 @@
+package models
+
+import (
+	"database/sql/driver"
+
+	"github.com/fiffu/diffwatch/lib/dbtypes"
+)
+
+// Items is an ordered list of extracted values, stored as a JSON array.
+type Items []string
+
+func (items Items) GormDataType() string {
+	return dbtypes.JSON(&items).GormDataType()
+}
+
+func (items Items) Value() (driver.Value, error) {
+	return dbtypes.JSON(&items).Value()
+}
+
+func (items *Items) Scan(src any) error {
+	return dbtypes.JSON(items).Scan(src)
+}
+
+// DiffItems returns the items only in curr and only in prev, comparing them as multisets.
+func DiffItems(prev, curr Items) (added, removed Items) {
+	counts := make(map[string]int)
+	for _, item := range prev {
+		counts[item]++
+	}
+	for _, item := range curr {
+		if counts[item] > 0 {
+			counts[item]--
+		} else {
+			added = append(added, item)
+		}
+	}
+	for _, item := range prev {
+		if counts[item] > 0 {
+			counts[item]--
+			removed = append(removed, item)
+		}
+	}
+	return
+}
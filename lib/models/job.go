@@ -0,0 +1,28 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// JobKind is the work that a job does for its target.
+type JobKind string
+
+const (
+	JobPoll  JobKind = "poll"  // Poll a target that one of its subscriptions is due on
+	JobChase JobKind = "chase" // Poll a target again shortly after it changed
+)
+
+// Job is a persisted unit of work for the snapshotter, leased by one worker at a time; a target has one of each kind.
+type Job struct {
+	ID             uint `gorm:"primarykey"`
+	CreatedAt      time.Time
+	Kind           JobKind      `gorm:"uniqueIndex:idx_jobs_kind_target"`
+	TargetID       uint         `gorm:"uniqueIndex:idx_jobs_kind_target"`
+	RunAt          time.Time    `gorm:"index"` // The job is due from this time
+	LeaseOwner     string       // Worker holding the lease, if any
+	LeaseExpiresAt sql.NullTime `gorm:"index"` // Until renewed, the lease can be taken by another worker after this
+	Attempts       int          // Times the job was claimed; more than once means that a worker died working on it
+}
+
+type Jobs []*Job
@@ -0,0 +1,145 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/fiffu/diffwatch/lib/dbtypes"
+)
+
+// NormalizeOp is a transformation applied to a subscription's content before it is digested and stored.
+type NormalizeOp string
+
+const (
+	NormalizeDelete    NormalizeOp = "delete"     // Delete every match of the regex
+	NormalizeReplace   NormalizeOp = "replace"    // Replace every match of the regex, expanding $1 etc. in the replacement
+	NormalizeLowercase NormalizeOp = "lowercase"  // Fold the content to lower case
+	NormalizeRound     NormalizeOp = "round"      // Round every number to the given decimal places; negative rounds to tens, hundreds...
+	NormalizeSortLines NormalizeOp = "sort_lines" // Sort the lines, so that reordering is not a change
+	NormalizeDropLines NormalizeOp = "drop_lines" // Remove lines that match the regex
+)
+
+// NormalizeStep is a step of a normalization pipeline, written as "op:argument", e.g. "replace:(\d+)px=>$1".
+type NormalizeStep struct {
+	Op          NormalizeOp `json:"op"`
+	Pattern     string      `json:"pattern,omitempty"`
+	Replacement string      `json:"replacement,omitempty"`
+	Places      int         `json:"places,omitempty"`
+}
+
+// ParseNormalizeStep parses and validates a step written as "op:argument".
+func ParseNormalizeStep(s string) (NormalizeStep, error) {
+	op, arg, _ := strings.Cut(s, ":")
+	step := NormalizeStep{Op: NormalizeOp(strings.ToLower(strings.TrimSpace(op)))}
+
+	switch step.Op {
+	case NormalizeDelete, NormalizeDropLines:
+		step.Pattern = arg
+	case NormalizeReplace:
+		pattern, replacement, ok := strings.Cut(arg, "=>")
+		if !ok {
+			return step, fmt.Errorf("replace step must be written as replace:pattern=>replacement, got %q", s)
+		}
+		step.Pattern, step.Replacement = pattern, replacement
+	case NormalizeRound:
+		places, err := strconv.Atoi(strings.TrimSpace(arg))
+		if err != nil {
+			return step, fmt.Errorf("round step must be written as round:places, got %q", s)
+		}
+		step.Places = places
+	case NormalizeLowercase, NormalizeSortLines:
+		if arg != "" {
+			return step, fmt.Errorf("%s step takes no argument, got %q", step.Op, s)
+		}
+	default:
+		return step, fmt.Errorf("unknown normalize step %q, expected one of delete, replace, lowercase, round, sort_lines or drop_lines", op)
+	}
+
+	if step.Pattern == "" && (step.Op == NormalizeDelete || step.Op == NormalizeReplace || step.Op == NormalizeDropLines) {
+		return step, fmt.Errorf("%s step requires a regex, got %q", step.Op, s)
+	}
+	if _, err := regexp.Compile(step.Pattern); err != nil {
+		return step, fmt.Errorf("invalid regex in normalize step %q: %w", s, err)
+	}
+	return step, nil
+}
+
+func (step NormalizeStep) String() string {
+	switch step.Op {
+	case NormalizeDelete, NormalizeDropLines:
+		return string(step.Op) + ":" + step.Pattern
+	case NormalizeReplace:
+		return string(step.Op) + ":" + step.Pattern + "=>" + step.Replacement
+	case NormalizeRound:
+		return string(step.Op) + ":" + strconv.Itoa(step.Places)
+	}
+	return string(step.Op)
+}
+
+var decimalNumber = regexp.MustCompile(`-?\d+(\.\d+)?`)
+
+// Apply runs the step over text, leaving it as is if its regex is invalid.
+func (step NormalizeStep) Apply(text string) string {
+	var re *regexp.Regexp
+	if step.Pattern != "" {
+		var err error
+		if re, err = regexp.Compile(step.Pattern); err != nil {
+			return text
+		}
+	}
+
+	switch step.Op {
+	case NormalizeDelete:
+		return re.ReplaceAllString(text, "")
+	case NormalizeReplace:
+		return re.ReplaceAllString(text, step.Replacement)
+	case NormalizeLowercase:
+		return strings.ToLower(text)
+	case NormalizeRound:
+		scale := math.Pow10(step.Places)
+		return decimalNumber.ReplaceAllStringFunc(text, func(match string) string {
+			f, err := strconv.ParseFloat(match, 64)
+			if err != nil {
+				return match
+			}
+			return strconv.FormatFloat(math.Round(f*scale)/scale, 'f', max(step.Places, 0), 64)
+		})
+	case NormalizeSortLines:
+		lines := strings.Split(text, "\n")
+		slices.Sort(lines)
+		return strings.Join(lines, "\n")
+	case NormalizeDropLines:
+		lines := strings.Split(text, "\n")
+		lines = slices.DeleteFunc(lines, re.MatchString)
+		return strings.Join(lines, "\n")
+	}
+	return text
+}
+
+// NormalizeSteps is an ordered normalization pipeline, stored as a JSON array.
+type NormalizeSteps []NormalizeStep
+
+func (steps NormalizeSteps) GormDataType() string {
+	return dbtypes.JSON(&steps).GormDataType()
+}
+
+func (steps NormalizeSteps) Value() (driver.Value, error) {
+	return dbtypes.JSON(&steps).Value()
+}
+
+func (steps *NormalizeSteps) Scan(src any) error {
+	return dbtypes.JSON(steps).Scan(src)
+}
+
+// Apply runs each step over text in order.
+func (steps NormalizeSteps) Apply(text string) string {
+	for _, step := range steps {
+		text = step.Apply(text)
+	}
+	return text
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseNormalizeStep(t *testing.T) {
+	for _, s := range []string{`delete:\s+`, `replace:(\d+)px=>$1`, "replace:x=>", "lowercase", "round:2", "round:-1", "sort_lines", "drop_lines:^Ad:"} {
+		step, err := ParseNormalizeStep(s)
+		if err != nil {
+			t.Errorf("ParseNormalizeStep(%q) = %v, want it accepted", s, err)
+			continue
+		}
+		if step.String() != s {
+			t.Errorf("ParseNormalizeStep(%q).String() = %q, want it unchanged", s, step.String())
+		}
+	}
+	for _, s := range []string{"delete", "delete:(", "replace:x", "replace:=>y", "round", "round:two", "lowercase:x", "sort_lines:x", "drop_lines:", "uppercase"} {
+		if _, err := ParseNormalizeStep(s); err == nil {
+			t.Errorf("ParseNormalizeStep(%q) succeeded, want an error", s)
+		}
+	}
+}
+
+func TestNormalizeStepsApply(t *testing.T) {
+	tests := []struct {
+		steps []string
+		text  string
+		want  string
+	}{
+		{[]string{`delete:\d{2}:\d{2}`}, "Updated 12:34 today", "Updated  today"},
+		{[]string{`replace:(\d+)px=>$1`}, "10px by 20px", "10 by 20"},
+		{[]string{"lowercase"}, "In Stock", "in stock"},
+		{[]string{"round:1"}, "1.26 and -3.14159 and 7", "1.3 and -3.1 and 7.0"},
+		{[]string{"round:0"}, "Price 9.99", "Price 10"},
+		{[]string{"round:-2"}, "1249 views", "1200 views"},
+		{[]string{"sort_lines"}, "b\nc\na", "a\nb\nc"},
+		{[]string{"drop_lines:^Ad:"}, "News\nAd: buy now\nMore news", "News\nMore news"},
+		{[]string{`delete:\d+ views`, "lowercase", "sort_lines"}, "Zed 5 views\nAlpha", "alpha\nzed "},
+	}
+	for _, tt := range tests {
+		var steps NormalizeSteps
+		for _, s := range tt.steps {
+			step, err := ParseNormalizeStep(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			steps = append(steps, step)
+		}
+		if got := steps.Apply(tt.text); got != tt.want {
+			t.Errorf("%q.Apply(%q) = %q, want %q", tt.steps, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestEndpointContentNormalize(t *testing.T) {
+	steps := NormalizeSteps{{Op: NormalizeDropLines, Pattern: "^ad"}, {Op: NormalizeSortLines}}
+
+	content := &EndpointContent{}
+	content.SetItems([]string{"b", "ad 1", "a"})
+	content.Normalize(steps)
+	if want := (Items{"a", "b"}); !reflect.DeepEqual(content.Items, want) || content.Text != "a\nb" {
+		t.Errorf("items = %q, text = %q; want %q", content.Items, content.Text, want)
+	}
+
+	content = &EndpointContent{Text: "b\nad 1\na"}
+	content.Normalize(steps)
+	if content.Text != "a\nb" || content.Items != nil {
+		t.Errorf("text = %q, items = %q; want the text normalized", content.Text, content.Items)
+	}
+}
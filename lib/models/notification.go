@@ -0,0 +1,39 @@
+package models
+
+import (
+	"database/sql/driver"
+	"time"
+
+	"github.com/fiffu/diffwatch/lib/dbtypes"
+)
+
+// PendingNotification is a change queued for its notifier's next digest.
+type PendingNotification struct {
+	ID             uint `gorm:"primarykey"`
+	CreatedAt      time.Time
+	NotifierID     uint `gorm:"index"`
+	SubscriptionID uint
+	Change         Change
+
+	Subscription Subscription
+}
+
+type PendingNotifications []*PendingNotification
+
+// Change holds copies of the snapshots around a change, as they may be purged before it is notified.
+type Change struct {
+	Previous *Snapshot // Nil if the subscription had no snapshot before
+	Current  *Snapshot
+}
+
+func (c Change) GormDataType() string {
+	return dbtypes.JSON(&c).GormDataType()
+}
+
+func (c Change) Value() (driver.Value, error) {
+	return dbtypes.JSON(&c).Value()
+}
+
+func (c *Change) Scan(src any) error {
+	return dbtypes.JSON(c).Scan(src)
+}
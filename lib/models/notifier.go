@@ -1,17 +1,59 @@
 package models
 
 import (
+	"database/sql"
+	"fmt"
 	"time"
 
 	"gorm.io/gorm"
 )
 
+// DeliveryMode determines when a notifier is told about changes to its subscriptions.
+type DeliveryMode string
+
+const (
+	DeliverImmediately DeliveryMode = "immediate" // One message per change, as soon as it is seen
+	DeliverHourly      DeliveryMode = "hourly"    // One digest of the changes, at the top of every hour
+	DeliverDaily       DeliveryMode = "daily"     // One digest of the changes, every day at the notifier's digest time
+)
+
 type Notifier struct {
 	gorm.Model
 	UserID             uint
 	Verified           bool
 	Platform           string
 	PlatformIdentifier string
+
+	DeliveryMode DeliveryMode `gorm:"default:immediate"`
+	DigestTime   string       // Local time of day that daily digests are sent at, as HH:MM
+	Timezone     string       // IANA time zone of the digest time, UTC if empty
+	NextDigestAt sql.NullTime `gorm:"index"` // When the pending notifications are next sent; null if there are none
+}
+
+// Delivery returns when the notifier is told about changes, defaulting to immediately for older rows.
+func (n *Notifier) Delivery() DeliveryMode {
+	if n.DeliveryMode == "" {
+		return DeliverImmediately
+	}
+	return n.DeliveryMode
+}
+
+// DigestSchedule returns when the notifier's digests are sent, or nil if it is notified immediately.
+func (n *Notifier) DigestSchedule() (Schedule, error) {
+	switch n.Delivery() {
+	case DeliverImmediately:
+		return nil, nil
+	case DeliverHourly:
+		return ParseSchedule("@hourly", "")
+	case DeliverDaily:
+		at, err := time.Parse("15:04", n.DigestTime)
+		if err != nil {
+			return nil, fmt.Errorf("invalid digest time %q, expected HH:MM", n.DigestTime)
+		}
+		return ParseSchedule(fmt.Sprintf("%d %d * * *", at.Minute(), at.Hour()), n.Timezone)
+	default:
+		return nil, fmt.Errorf("unknown delivery mode %q, expected one of immediate, hourly or daily", n.DeliveryMode)
+	}
 }
 
 type NotifierConfirmation struct {
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNotifierDigestSchedule(t *testing.T) {
+	after := time.Date(2024, 6, 1, 12, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		notifier Notifier
+		want     time.Time // Zero if notified immediately
+		wantErr  bool
+	}{
+		{"older rows are immediate", Notifier{}, time.Time{}, false},
+		{"immediate", Notifier{DeliveryMode: DeliverImmediately}, time.Time{}, false},
+		{"hourly", Notifier{DeliveryMode: DeliverHourly}, time.Date(2024, 6, 1, 13, 0, 0, 0, time.UTC), false},
+		{"daily", Notifier{DeliveryMode: DeliverDaily, DigestTime: "08:15"}, time.Date(2024, 6, 2, 8, 15, 0, 0, time.UTC), false},
+		{"daily later today", Notifier{DeliveryMode: DeliverDaily, DigestTime: "18:00"}, time.Date(2024, 6, 1, 18, 0, 0, 0, time.UTC), false},
+		{"daily in a timezone", Notifier{DeliveryMode: DeliverDaily, DigestTime: "21:00", Timezone: "Asia/Singapore"}, time.Date(2024, 6, 1, 13, 0, 0, 0, time.UTC), false},
+		{"invalid digest time", Notifier{DeliveryMode: DeliverDaily, DigestTime: "8am"}, time.Time{}, true},
+		{"unknown mode", Notifier{DeliveryMode: "weekly"}, time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := tt.notifier.DigestSchedule()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DigestSchedule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.want.IsZero() {
+				if schedule != nil {
+					t.Errorf("DigestSchedule() = %v, want none", schedule)
+				}
+				return
+			}
+			if got := schedule.Next(after); !got.Equal(tt.want) {
+				t.Errorf("Next() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"database/sql/driver"
+	"time"
+
+	"github.com/fiffu/diffwatch/lib/dbtypes"
+)
+
+// RecentDigestsKept is how many contents a subscription remembers having changed away from.
+const RecentDigestsKept = 10
+
+// RecentDigest is a content that a subscription changed away from.
+type RecentDigest struct {
+	Digest string    `json:"digest"`
+	SeenAt time.Time `json:"seen_at"` // When the content was last seen, i.e. when it changed away
+}
+
+// RecentDigests are the contents a subscription recently changed away from, most recent first.
+type RecentDigests []RecentDigest
+
+func (r RecentDigests) GormDataType() string {
+	return dbtypes.JSON(&r).GormDataType()
+}
+
+func (r RecentDigests) Value() (driver.Value, error) {
+	return dbtypes.JSON(&r).Value()
+}
+
+func (r *RecentDigests) Scan(src any) error {
+	return dbtypes.JSON(r).Scan(src)
+}
+
+// SeenSince reports whether the content with the given digest was seen at or after the given time.
+func (r RecentDigests) SeenSince(digest string, since time.Time) bool {
+	for _, recent := range r {
+		if recent.Digest == digest {
+			return !recent.SeenAt.Before(since)
+		}
+	}
+	return false
+}
+
+// Push returns the digests with the given one moved to the front, keeping at most RecentDigestsKept of them.
+func (r RecentDigests) Push(digest string, seenAt time.Time) RecentDigests {
+	out := RecentDigests{{Digest: digest, SeenAt: seenAt}}
+	for _, recent := range r {
+		if recent.Digest != digest && len(out) < RecentDigestsKept {
+			out = append(out, recent)
+		}
+	}
+	return out
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRecentDigests(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	var recent RecentDigests
+	for i := 0; i < RecentDigestsKept+2; i++ {
+		recent = recent.Push(fmt.Sprint(i), now.Add(time.Duration(i)*time.Minute))
+	}
+
+	if len(recent) != RecentDigestsKept {
+		t.Fatalf("Push() kept %d digests, want %d", len(recent), RecentDigestsKept)
+	}
+	if recent[0].Digest != fmt.Sprint(RecentDigestsKept+1) {
+		t.Errorf("most recent digest = %s, want the last pushed", recent[0].Digest)
+	}
+	if recent.SeenSince("0", now) || recent.SeenSince("1", now) {
+		t.Error("SeenSince() reports digests pushed out of the list")
+	}
+
+	// Pushing a digest again moves it to the front, seen at the new time
+	recent = recent.Push("5", now.Add(time.Hour))
+	if len(recent) != RecentDigestsKept || recent[0].Digest != "5" || !recent.SeenSince("5", now.Add(time.Hour)) {
+		t.Errorf("Push() of a kept digest = %+v", recent)
+	}
+	if !recent.SeenSince("6", now.Add(6*time.Minute)) || recent.SeenSince("6", now.Add(7*time.Minute)) {
+		t.Error("SeenSince() doesn't compare against when the digest was seen")
+	}
+}
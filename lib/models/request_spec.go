@@ -0,0 +1,81 @@
+package models
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"strings"
+
+	"github.com/fiffu/diffwatch/lib/dbtypes"
+)
+
+// RequestSpec customizes the HTTP request that is issued every time a subscription's endpoint is polled.
+type RequestSpec struct {
+	Method        string // Defaults to GET
+	Headers       Headers
+	Body          string
+	BasicUser     string
+	BasicPassword SealedString
+	BearerToken   SealedString
+}
+
+// AuthScheme names the kind of credentials configured on the request, if any.
+func (spec RequestSpec) AuthScheme() string {
+	switch {
+	case spec.BearerToken != "":
+		return "bearer"
+	case spec.BasicUser != "" || spec.BasicPassword != "":
+		return "basic"
+	}
+	return ""
+}
+
+var nonSecretHeaders = map[string]bool{
+	"Accept":          true,
+	"Accept-Language": true,
+	"Cache-Control":   true,
+	"Content-Type":    true,
+	"Referer":         true,
+	"User-Agent":      true,
+}
+
+// IsSecretHeader reports whether a header's value may hold credentials, i.e. it isn't known to be harmless.
+func IsSecretHeader(name string) bool {
+	return !nonSecretHeaders[http.CanonicalHeaderKey(name)]
+}
+
+// Headers holds extra request headers, stored as a JSON object that is sealed if any header may be secret.
+type Headers http.Header
+
+func (h Headers) GormDataType() string {
+	return dbtypes.JSON(&h).GormDataType()
+}
+
+func (h Headers) Value() (driver.Value, error) {
+	if len(h) == 0 {
+		return nil, nil
+	}
+	v, err := dbtypes.JSON(&h).Value()
+	if err != nil {
+		return nil, err
+	}
+	for name := range h {
+		if IsSecretHeader(name) {
+			return SealedString(v.(string)).Value()
+		}
+	}
+	return v, nil
+}
+
+func (h *Headers) Scan(src any) error {
+	if b, ok := src.([]byte); ok {
+		src = string(b)
+	}
+	if raw, ok := src.(string); ok && strings.HasPrefix(raw, sealedPrefix) {
+		var sealed SealedString
+		if err := sealed.Scan(raw); err != nil {
+			return err
+		}
+		src = string(sealed)
+	}
+	return dbtypes.JSON(h).Scan(src)
+}
@@ -0,0 +1,138 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/fiffu/diffwatch/lib/dbtypes"
+)
+
+// RuleKind determines what a rule looks for when a subscription's content changes.
+type RuleKind string
+
+const (
+	RuleAbove      RuleKind = "above"      // The number in the content rises above the threshold
+	RuleBelow      RuleKind = "below"      // The number in the content falls below the threshold
+	RuleCrosses    RuleKind = "crosses"    // The number in the content crosses the threshold, in either direction
+	RuleAppears    RuleKind = "appears"    // The keyword appears in the content, ignoring case
+	RuleDisappears RuleKind = "disappears" // The keyword disappears from the content, ignoring case
+	RuleMatches    RuleKind = "matches"    // The regular expression matches the content
+)
+
+// Rule is a condition on a change to a subscription's content, written as "kind:argument", e.g. "below:99.90".
+type Rule struct {
+	Kind RuleKind `json:"kind"`
+	Arg  string   `json:"arg"`
+}
+
+// ParseRule parses and validates a rule written as "kind:argument".
+func ParseRule(s string) (Rule, error) {
+	kind, arg, ok := strings.Cut(s, ":")
+	if !ok || arg == "" {
+		return Rule{}, fmt.Errorf("rule must be written as kind:argument, got %q", s)
+	}
+
+	rule := Rule{RuleKind(strings.ToLower(strings.TrimSpace(kind))), arg}
+	switch rule.Kind {
+	case RuleAbove, RuleBelow, RuleCrosses:
+		if _, err := strconv.ParseFloat(strings.TrimSpace(arg), 64); err != nil {
+			return Rule{}, fmt.Errorf("threshold of rule %q is not a number", s)
+		}
+	case RuleAppears, RuleDisappears:
+	case RuleMatches:
+		if _, err := regexp.Compile(arg); err != nil {
+			return Rule{}, fmt.Errorf("invalid regex in rule %q: %w", s, err)
+		}
+	default:
+		return Rule{}, fmt.Errorf("unknown rule kind %q, expected one of above, below, crosses, appears, disappears or matches", kind)
+	}
+	return rule, nil
+}
+
+func (r Rule) String() string {
+	return string(r.Kind) + ":" + r.Arg
+}
+
+// Fires reports whether the change from prev, nil if there was none, to curr satisfies the rule.
+func (r Rule) Fires(prev *string, curr string) bool {
+	switch r.Kind {
+	case RuleAbove, RuleBelow, RuleCrosses:
+		threshold, _ := strconv.ParseFloat(strings.TrimSpace(r.Arg), 64)
+		after, ok := ParseNumber(curr)
+		if !ok {
+			return false
+		}
+		isAbove, isBelow := after > threshold, after < threshold
+		var wasAbove, wasBelow bool
+		if prev != nil {
+			if before, ok := ParseNumber(*prev); ok {
+				wasAbove, wasBelow = before > threshold, before < threshold
+			}
+		}
+		switch r.Kind {
+		case RuleAbove:
+			return isAbove && !wasAbove
+		case RuleBelow:
+			return isBelow && !wasBelow
+		default:
+			return isAbove && wasBelow || isBelow && wasAbove
+		}
+
+	case RuleAppears:
+		return containsFold(curr, r.Arg) && (prev == nil || !containsFold(*prev, r.Arg))
+	case RuleDisappears:
+		return prev != nil && containsFold(*prev, r.Arg) && !containsFold(curr, r.Arg)
+	case RuleMatches:
+		re, err := regexp.Compile(r.Arg)
+		return err == nil && re.MatchString(curr)
+	}
+	return false
+}
+
+// Rules is a list of rules, stored as a JSON array.
+type Rules []Rule
+
+func (rules Rules) GormDataType() string {
+	return dbtypes.JSON(&rules).GormDataType()
+}
+
+func (rules Rules) Value() (driver.Value, error) {
+	return dbtypes.JSON(&rules).Value()
+}
+
+func (rules *Rules) Scan(src any) error {
+	return dbtypes.JSON(rules).Scan(src)
+}
+
+// Evaluate returns the first rule that fires on the change from prev, which may be nil, to curr.
+func (rules Rules) Evaluate(prev, curr *Snapshot) *Rule {
+	var prevContent *string
+	if prev != nil {
+		prevContent = &prev.Content
+	}
+	for i := range rules {
+		if rules[i].Fires(prevContent, curr.Content) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+var number = regexp.MustCompile(`[-+]?\d[\d,]*(\.\d+)?`)
+
+// ParseNumber parses the first number found in s, ignoring thousands separators, e.g. 1234.5 in "Now $1,234.50!".
+func ParseNumber(s string) (float64, bool) {
+	match := number.FindString(s)
+	if match == "" {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(strings.ReplaceAll(match, ",", ""), 64)
+	return f, err == nil
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
@@ -0,0 +1,93 @@
+package models
+
+import "testing"
+
+func TestParseRule(t *testing.T) {
+	for _, s := range []string{"below:99.90", "ABOVE: 10", "crosses:-1", "appears:in stock", "disappears:sold out", `matches:v\d+\.0\.0`, "appears:a:b"} {
+		if _, err := ParseRule(s); err != nil {
+			t.Errorf("ParseRule(%q) = %v, want it accepted", s, err)
+		}
+	}
+	for _, s := range []string{"below", "below:", "below:cheap", "matches:(", "sometimes:x", ":x"} {
+		if _, err := ParseRule(s); err == nil {
+			t.Errorf("ParseRule(%q) succeeded, want an error", s)
+		}
+	}
+}
+
+func TestRuleFires(t *testing.T) {
+	str := func(s string) *string { return &s }
+	tests := []struct {
+		rule string
+		prev *string
+		curr string
+		want bool
+	}{
+		{"below:100", str("$120.00"), "$99.90", true},
+		{"below:100", str("$99.00"), "$89.90", false}, // Was already below
+		{"below:100", nil, "$99.90", true},
+		{"below:100", str("$120"), "Sold out", false},
+		{"below:100", str("n/a"), "$99.90", true},
+		{"above:1000", str("999"), "$1,234.50", true},
+		{"above:1000", str("1,001"), "1,234", false},
+		{"crosses:50", str("40"), "60", true},
+		{"crosses:50", str("60"), "40", true},
+		{"crosses:50", str("60"), "70", false},
+		{"crosses:50", nil, "60", false},
+		{"appears:In Stock", str("Sold out"), "Now in stock!", true},
+		{"appears:in stock", str("In stock soon"), "In stock", false},
+		{"appears:in stock", nil, "in stock", true},
+		{"disappears:sold out", str("SOLD OUT"), "Available", true},
+		{"disappears:sold out", nil, "Available", false},
+		{"disappears:sold out", str("Available"), "Still available", false},
+		{`matches:v\d+\.0\.0`, str("v1.2.3"), "v2.0.0", true},
+		{`matches:v\d+\.0\.0`, str("v2.0.0"), "v2.0.1", false},
+	}
+	for _, tt := range tests {
+		rule, err := ParseRule(tt.rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := rule.Fires(tt.prev, tt.curr); got != tt.want {
+			prev := "nil"
+			if tt.prev != nil {
+				prev = *tt.prev
+			}
+			t.Errorf("%s fires on %q -> %q = %v, want %v", tt.rule, prev, tt.curr, got, tt.want)
+		}
+	}
+}
+
+func TestRulesEvaluate(t *testing.T) {
+	rules := Rules{{RuleAppears, "sale"}, {RuleBelow, "10"}}
+	prev := &Snapshot{Content: "$12"}
+
+	if got := rules.Evaluate(prev, &Snapshot{Content: "$9 on sale"}); got == nil || got.Kind != RuleAppears {
+		t.Errorf("Evaluate() = %v, want the first rule that fires", got)
+	}
+	if got := rules.Evaluate(prev, &Snapshot{Content: "$9"}); got == nil || got.Kind != RuleBelow {
+		t.Errorf("Evaluate() = %v, want below:10", got)
+	}
+	if got := rules.Evaluate(prev, &Snapshot{Content: "$11"}); got != nil {
+		t.Errorf("Evaluate() = %v, want no rule", got)
+	}
+}
+
+func TestParseNumber(t *testing.T) {
+	tests := []struct {
+		s    string
+		want float64
+		ok   bool
+	}{
+		{"Now $1,234.50!", 1234.5, true},
+		{"-3 degrees", -3, true},
+		{"+7.25%", 7.25, true},
+		{"Price: 42", 42, true},
+		{"no numbers", 0, false},
+	}
+	for _, tt := range tests {
+		if got, ok := ParseNumber(tt.s); got != tt.want || ok != tt.ok {
+			t.Errorf("ParseNumber(%q) = %v, %v; want %v, %v", tt.s, got, ok, tt.want, tt.ok)
+		}
+	}
+}
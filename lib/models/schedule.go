@@ -0,0 +1,218 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MinPollInterval is the shortest interval a subscription can be polled at, and the resolution of cron schedules.
+const MinPollInterval = time.Minute
+
+// ErrScheduleNeverFires is returned when a subscription's schedule has no more times to poll at.
+var ErrScheduleNeverFires = errors.New("schedule never fires")
+
+// Schedule decides when a subscription is next polled.
+type Schedule interface {
+	// Next returns the first time strictly after the given time that the subscription is due.
+	Next(after time.Time) time.Time
+}
+
+// cronMacros are shorthands for common cron expressions.
+var cronMacros = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// ParseSchedule parses an interval such as "1h30m", or a five-field cron expression evaluated in the time zone.
+func ParseSchedule(expr, timezone string) (Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if d, err := time.ParseDuration(expr); err == nil {
+		if d < MinPollInterval {
+			return nil, fmt.Errorf("schedule interval must be at least %s, got %s", MinPollInterval, d)
+		}
+		return IntervalSchedule(d), nil
+	}
+
+	loc := time.UTC
+	if timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(timezone); err != nil {
+			return nil, fmt.Errorf("unknown time zone %q", timezone)
+		}
+	}
+	if macro, ok := cronMacros[strings.ToLower(expr)]; ok {
+		expr = macro
+	}
+	cron, err := parseCron(expr, loc)
+	if err != nil {
+		return nil, fmt.Errorf("schedule %q is neither an interval like 15m nor a cron expression: %w", expr, err)
+	}
+	if cron.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("cron expression %q: %w", expr, ErrScheduleNeverFires)
+	}
+	return cron, nil
+}
+
+// IntervalSchedule polls at a fixed interval after the last poll.
+type IntervalSchedule time.Duration
+
+func (s IntervalSchedule) Next(after time.Time) time.Time {
+	return after.Add(time.Duration(s))
+}
+
+// CronSchedule polls at the minutes matching a cron expression, each field held as a bit set.
+type CronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny                bool // Whether the day fields were "*"; if neither was, a day matching either is due
+	loc                           *time.Location
+}
+
+type cronField struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	cronMinute = cronField{"minute", 0, 59, nil}
+	cronHour   = cronField{"hour", 0, 23, nil}
+	cronDom    = cronField{"day of month", 1, 31, nil}
+	cronMonth  = cronField{"month", 1, 12, map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	cronDow = cronField{"day of week", 0, 7, map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+func parseCron(expr string, loc *time.Location) (*CronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields, got %d", len(fields))
+	}
+
+	s := &CronSchedule{loc: loc, domAny: fields[2] == "*", dowAny: fields[4] == "*"}
+	var err error
+	for i, f := range []struct {
+		bits *uint64
+		spec cronField
+	}{
+		{&s.minute, cronMinute},
+		{&s.hour, cronHour},
+		{&s.dom, cronDom},
+		{&s.month, cronMonth},
+		{&s.dow, cronDow},
+	} {
+		if *f.bits, err = f.spec.parse(fields[i]); err != nil {
+			return nil, err
+		}
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1 // 7 is Sunday too
+	}
+	return s, nil
+}
+
+// parse parses a comma-separated list of "*", values and ranges, each optionally followed by a "/step".
+func (f cronField) parse(field string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepStr); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q in %s field", stepStr, f.name)
+			}
+		}
+
+		var lo, hi int
+		if rng == "*" {
+			lo, hi = f.min, f.max
+		} else {
+			loStr, hiStr, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = f.value(loStr); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = f.value(hiStr); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				hi = f.max // "5/15" means from 5 onwards
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("range %q in %s field is backwards", rng, f.name)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func (f cronField) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q in %s field", s, f.name)
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("%s must be between %d and %d, got %d", f.name, f.min, f.max, v)
+	}
+	return v, nil
+}
+
+// Next returns the first matching wall clock minute after the given time, or zero if none is within five years.
+func (s *CronSchedule) Next(after time.Time) time.Time {
+	// Search the wall clock as if it were UTC, which has no gaps or repeats
+	local := after.In(s.loc)
+	wall := time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), local.Minute(), 0, 0, time.UTC).Add(time.Minute)
+	limit := wall.AddDate(5, 0, 0)
+
+	// Move forward by the largest unit that doesn't match, until everything does
+	for wall.Before(limit) {
+		y, mon, d := wall.Date()
+		switch {
+		case s.month&(1<<int(mon)) == 0:
+			wall = time.Date(y, mon+1, 1, 0, 0, 0, 0, time.UTC)
+		case !s.dayMatches(wall):
+			wall = time.Date(y, mon, d+1, 0, 0, 0, 0, time.UTC)
+		case s.hour&(1<<wall.Hour()) == 0:
+			wall = wall.Truncate(time.Hour).Add(time.Hour)
+		case s.minute&(1<<wall.Minute()) == 0:
+			wall = wall.Add(time.Minute)
+		default:
+			t := time.Date(y, mon, d, wall.Hour(), wall.Minute(), 0, 0, s.loc)
+			if got := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC); !got.Equal(wall) {
+				t = t.Add(wall.Sub(got)) // In a gap, which time.Date resolves to before it
+			}
+			if t.After(after) {
+				return t
+			}
+			// The clocks went back, and this time already passed before they did
+			wall = wall.Add(time.Minute)
+		}
+	}
+	return time.Time{}
+}
+
+func (s *CronSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<t.Day()) != 0
+	dowMatch := s.dow&(1<<int(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
@@ -0,0 +1,192 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("time zone %s unavailable: %v", name, err)
+	}
+	return loc
+}
+
+// nextTimes returns the next n times the schedule fires after the given time.
+func nextTimes(s Schedule, after time.Time, n int) []time.Time {
+	times := make([]time.Time, n)
+	for i := range times {
+		after = s.Next(after)
+		times[i] = after
+	}
+	return times
+}
+
+func TestCronScheduleNext(t *testing.T) {
+	// A Wednesday
+	start := time.Date(2024, 5, 15, 10, 7, 30, 0, time.UTC)
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 5, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		expr string
+		want []time.Time
+	}{
+		{"* * * * *", []time.Time{at(15, 10, 8), at(15, 10, 9)}},
+		{"*/15 * * * *", []time.Time{at(15, 10, 15), at(15, 10, 30), at(15, 10, 45)}},
+		{"5/20 * * * *", []time.Time{at(15, 10, 25), at(15, 10, 45), at(15, 11, 5)}},
+		{"0,30 9-10 * * *", []time.Time{at(15, 10, 30), at(16, 9, 0), at(16, 9, 30)}},
+		{"0 9 * * mon-fri", []time.Time{at(16, 9, 0), at(17, 9, 0), at(20, 9, 0)}},
+		{"0 0 * jun *", []time.Time{time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)}},
+
+		// Macros
+		{"@hourly", []time.Time{at(15, 11, 0), at(15, 12, 0)}},
+		{"@daily", []time.Time{at(16, 0, 0), at(17, 0, 0)}},
+		{"@WEEKLY", []time.Time{at(19, 0, 0), at(26, 0, 0)}},
+		{"@monthly", []time.Time{time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)}},
+
+		// Day of week 7 is Sunday, as is 0
+		{"0 12 * * 7", []time.Time{at(19, 12, 0), at(26, 12, 0)}},
+		{"0 12 * * 0", []time.Time{at(19, 12, 0), at(26, 12, 0)}},
+		{"0 12 * * 6-7", []time.Time{at(18, 12, 0), at(19, 12, 0), at(25, 12, 0)}},
+
+		// When both day fields are restricted, a day matching either is due
+		{"0 0 20 * fri", []time.Time{at(17, 0, 0), at(20, 0, 0), at(24, 0, 0)}},
+		// When only one is, it alone decides
+		{"0 0 20 * *", []time.Time{at(20, 0, 0), time.Date(2024, 6, 20, 0, 0, 0, 0, time.UTC)}},
+		{"0 0 * * fri", []time.Time{at(17, 0, 0), at(24, 0, 0)}},
+
+		// Only months with the day
+		{"0 0 31 * *", []time.Time{at(31, 0, 0), time.Date(2024, 7, 31, 0, 0, 0, 0, time.UTC)}},
+		{"0 0 29 feb *", []time.Time{time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			s, err := ParseSchedule(tt.expr, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := nextTimes(s, start, len(tt.want))
+			for i := range tt.want {
+				if !got[i].Equal(tt.want[i]) {
+					t.Errorf("Next #%d = %s, want %s", i+1, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCronScheduleNextAcrossDaylightSaving(t *testing.T) {
+	ny := mustLoadLocation(t, "America/New_York")
+
+	tests := []struct {
+		name  string
+		expr  string
+		after time.Time
+		want  []time.Time
+	}{
+		{
+			name:  "is evaluated in its time zone",
+			expr:  "0 9 * * *",
+			after: time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC), // 08:00 EDT
+			want: []time.Time{
+				time.Date(2024, 7, 1, 13, 0, 0, 0, time.UTC),
+				time.Date(2024, 7, 2, 13, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:  "keeps its wall clock time when the clocks go forward",
+			expr:  "0 9 * * *",
+			after: time.Date(2024, 3, 9, 12, 0, 0, 0, ny),
+			want: []time.Time{
+				time.Date(2024, 3, 10, 9, 0, 0, 0, ny), // 13:00 UTC, an hour earlier than the day before
+				time.Date(2024, 3, 11, 9, 0, 0, 0, ny),
+			},
+		},
+		{
+			name:  "fires after the gap for a time that the clocks skip",
+			expr:  "30 2 * * *",
+			after: time.Date(2024, 3, 9, 12, 0, 0, 0, ny),
+			want: []time.Time{
+				time.Date(2024, 3, 10, 7, 30, 0, 0, time.UTC), // 03:30 EDT
+				time.Date(2024, 3, 11, 2, 30, 0, 0, ny),
+			},
+		},
+		{
+			name:  "fires once for a time that the clocks repeat",
+			expr:  "30 1 * * *",
+			after: time.Date(2024, 11, 2, 12, 0, 0, 0, ny),
+			want: []time.Time{
+				time.Date(2024, 11, 3, 5, 30, 0, 0, time.UTC), // 01:30 EDT
+				time.Date(2024, 11, 4, 1, 30, 0, 0, ny),
+			},
+		},
+		{
+			name:  "doesn't go back in time while the clocks repeat",
+			expr:  "*/30 * * * *",
+			after: time.Date(2024, 11, 3, 5, 45, 0, 0, time.UTC), // 01:45 EDT
+			want: []time.Time{
+				time.Date(2024, 11, 3, 7, 0, 0, 0, time.UTC), // 02:00 EST
+				time.Date(2024, 11, 3, 7, 30, 0, 0, time.UTC),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := ParseSchedule(tt.expr, ny.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := nextTimes(s, tt.after, len(tt.want))
+			for i := range tt.want {
+				if !got[i].Equal(tt.want[i]) {
+					t.Errorf("Next #%d = %s, want %s", i+1, got[i].In(ny), tt.want[i].In(ny))
+				}
+			}
+		})
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	if s, err := ParseSchedule("1h30m", ""); err != nil || s != IntervalSchedule(90*time.Minute) {
+		t.Errorf(`ParseSchedule("1h30m") = %v, %v; want a 90m interval`, s, err)
+	}
+
+	for _, expr := range []string{
+		"30s",
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"* * * * someday",
+		"@yearly",
+	} {
+		t.Run(expr, func(t *testing.T) {
+			if _, err := ParseSchedule(expr, ""); err == nil {
+				t.Errorf("ParseSchedule(%q) succeeded, want an error", expr)
+			}
+		})
+	}
+
+	if _, err := ParseSchedule("0 0 * * *", "Mars/Olympus_Mons"); err == nil {
+		t.Error("ParseSchedule with an unknown time zone succeeded, want an error")
+	}
+}
+
+func TestParseScheduleRejectsNeverFiring(t *testing.T) {
+	for _, expr := range []string{"0 0 30 feb *", "0 0 31 apr,jun,sep,nov *"} {
+		_, err := ParseSchedule(expr, "")
+		if !errors.Is(err, ErrScheduleNeverFires) {
+			t.Errorf("ParseSchedule(%q) = %v, want ErrScheduleNeverFires", expr, err)
+		}
+	}
+}
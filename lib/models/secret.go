@@ -0,0 +1,119 @@
+package models
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const sealedPrefix = "v1:"
+
+var (
+	sealer    cipher.AEAD
+	digestKey = randomDigestKey()
+)
+
+// SetSecretKey sets the key, of any length, that SealedString columns are encrypted with.
+func SetSecretKey(key string) error {
+	if key == "" {
+		sealer, digestKey = nil, randomDigestKey()
+		return nil
+	}
+	sum := sha256.Sum256([]byte(key))
+	mac := hmac.New(sha256.New, sum[:])
+	mac.Write([]byte("digest"))
+	digestKey = mac.Sum(nil)
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return err
+	}
+	sealer, err = cipher.NewGCM(block)
+	return err
+}
+
+// SecretDigest returns a hex HMAC of b under the secret key.
+func SecretDigest(b []byte) string {
+	mac := hmac.New(sha256.New, digestKey)
+	mac.Write(b)
+	return fmt.Sprintf("%x", mac.Sum(nil))
+}
+
+// randomDigestKey keys digests without a secret key; secrets can't be stored then, so their digests needn't outlast
+// the process.
+func randomDigestKey() []byte {
+	key := make([]byte, sha256.Size)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// SealedString is a string that is encrypted with AES-GCM before it is written to the database.
+type SealedString string
+
+func (s SealedString) GormDataType() string {
+	return "text"
+}
+
+func (s SealedString) Value() (driver.Value, error) {
+	if s == "" {
+		return "", nil
+	}
+	if sealer == nil {
+		return nil, errors.New("a SECRET_KEY must be configured to store secrets")
+	}
+	nonce := make([]byte, sealer.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := sealer.Seal(nonce, nonce, []byte(s), nil)
+	return sealedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (s *SealedString) Scan(src any) error {
+	var raw string
+	switch v := src.(type) {
+	case nil:
+		*s = ""
+		return nil
+	case string:
+		raw = v
+	case []byte:
+		raw = string(v)
+	default:
+		return fmt.Errorf("unable to scan %T into SealedString", src)
+	}
+	if raw == "" {
+		*s = ""
+		return nil
+	}
+
+	encoded, ok := strings.CutPrefix(raw, sealedPrefix)
+	if !ok {
+		return errors.New("sealed value has an unknown format")
+	}
+	if sealer == nil {
+		return errors.New("a SECRET_KEY must be configured to read secrets")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return err
+	}
+	if len(sealed) < sealer.NonceSize() {
+		return errors.New("sealed value is truncated")
+	}
+	nonce, ciphertext := sealed[:sealer.NonceSize()], sealed[sealer.NonceSize():]
+	plaintext, err := sealer.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return fmt.Errorf("unable to decrypt sealed value: %w", err)
+	}
+	*s = SealedString(plaintext)
+	return nil
+}
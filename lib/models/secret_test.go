@@ -0,0 +1,58 @@
+package models
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+)
+
+func TestSealedString(t *testing.T) {
+	if err := SetSecretKey("test key"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { SetSecretKey("") })
+
+	v, err := SealedString("hunter2").Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v == "hunter2" {
+		t.Error("Value() stored the plaintext")
+	}
+	var got SealedString
+	if err := got.Scan(v); err != nil || got != "hunter2" {
+		t.Errorf("Scan() = %q, %v; want the plaintext", got, err)
+	}
+
+	if err := SetSecretKey("another key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := got.Scan(v); err == nil {
+		t.Error("Scan() under another key succeeded, want an error")
+	}
+}
+
+func TestSecretDigestIsKeyed(t *testing.T) {
+	secret := []byte("hunter2")
+	t.Cleanup(func() { SetSecretKey("") })
+
+	for _, key := range []string{"", "test key"} {
+		if err := SetSecretKey(key); err != nil {
+			t.Fatal(err)
+		}
+		if got := SecretDigest(secret); got == fmt.Sprintf("%x", sha256.Sum256(secret)) {
+			t.Errorf("SecretDigest() with key %q is a plain SHA-256", key)
+		}
+	}
+
+	if err := SetSecretKey("test key"); err != nil {
+		t.Fatal(err)
+	}
+	before := SecretDigest(secret)
+	if err := SetSecretKey("test key"); err != nil {
+		t.Fatal(err)
+	}
+	if got := SecretDigest(secret); got != before {
+		t.Error("SecretDigest() changed under the same key")
+	}
+}
@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// Setting is a value changed at runtime, e.g. through the admin API, that every process using the database shares.
+type Setting struct {
+	Key       string `gorm:"primaryKey"`
+	Value     string
+	UpdatedAt time.Time
+}
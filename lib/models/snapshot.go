@@ -1,22 +1,49 @@
 package models
 
 import (
+	"database/sql"
 	"time"
 
+	"github.com/fiffu/diffwatch/lib/diff"
+
 	"gorm.io/gorm"
 )
 
 type Snapshot struct {
-	Timestamp      time.Time
-	UserID         uint `gorm:"index:idx_user_subscription"`
-	SubscriptionID uint `gorm:"index:idx_user_subscription"`
+	Timestamp      time.Time    // When the content was last seen
+	CapturedAt     sql.NullTime // When the content was first seen, i.e. when it changed; null for older snapshots
+	UserID         uint         `gorm:"index:idx_user_subscription"`
+	SubscriptionID uint         `gorm:"index:idx_user_subscription"`
 	Content        string
+	Items          Items       // Matched items for multi-node subscriptions, in document order
+	Entries        FeedEntries // Entries of the feed for feed subscriptions, in feed order
+	FiredRule      string      // The subscription's rule that fired on this change, if any
+	Reverted       bool        // The content went back to one seen within the flap window, so the change wasn't notified
+	Diff           diff.Hunks  // Changes from the previous snapshot's content, if there was one
 	ContentDigest  string
+
+	// Blobs of the raw response body and JSON headers; empty if it wasn't archived
+	RawBodyDigest   string
+	RawHeaderDigest string
 }
 
 type Snapshots []Snapshot
 
+// IsList reports whether the snapshot was taken from a multi-node subscription.
+func (s *Snapshot) IsList() bool {
+	return s.Items != nil
+}
+
+// IsFeed reports whether the snapshot was taken from a feed subscription.
+func (s *Snapshot) IsFeed() bool {
+	return s.Entries != nil
+}
+
 func (s *Snapshot) BeforeCreate(tx *gorm.DB) error {
-	s.ContentDigest = DigestContent(s.Content)
+	if s.IsFeed() {
+		s.ContentDigest = s.Entries.Digest()
+	} else {
+		s.ContentDigest = DigestContent(s.Content)
+	}
 	return nil
 }
@@ -2,22 +2,121 @@ package models
 
 import (
 	"database/sql"
+	"time"
 
 	"gorm.io/gorm"
 )
 
+// SelectorKind determines how a subscription's selector expression is evaluated against the endpoint's response.
+type SelectorKind string
+
+const (
+	SelectorXPath    SelectorKind = "xpath"    // XPath evaluated against the parsed HTML document
+	SelectorJSONPath SelectorKind = "jsonpath" // JSONPath evaluated against the decoded JSON body
+	SelectorCSS      SelectorKind = "css"      // CSS selector evaluated against the parsed HTML document
+	SelectorFeed     SelectorKind = "feed"     // RSS or Atom feed, tracked entry by entry; takes no selector expression
+)
+
+// ExtractMode determines what is taken from the nodes matched by an XPath or CSS selector.
+type ExtractMode string
+
+const (
+	ExtractText      ExtractMode = "text"       // The text within the node, with whitespace compacted
+	ExtractAttribute ExtractMode = "attribute"  // The value of one of the node's attributes, with relative URLs resolved
+	ExtractOuterHTML ExtractMode = "outer_html" // The node's markup, including the node itself
+	ExtractInnerHTML ExtractMode = "inner_html" // The markup of the node's children
+)
+
+// WatchSpec is what is fetched and extracted for a subscription; subscriptions with the same spec share a Target.
+type WatchSpec struct {
+	Endpoint     string
+	XPath        string         // Selector expression, interpreted according to SelectorKind
+	SelectorKind SelectorKind   `gorm:"default:xpath"`
+	MultiNode    bool           // Capture every node matched by the selector as a list, instead of only the first
+	ExtractMode  ExtractMode    `gorm:"default:text"`
+	ExtractAttr  string         // Attribute to extract in attribute mode; optional if the XPath already selects it
+	Request      RequestSpec    `gorm:"embedded;embeddedPrefix:request_"`
+	Charset      string         // Overrides the charset detected from the response, for pages that declare the wrong one
+	Normalize    NormalizeSteps // Applied in order to the extracted content, before it is digested and stored
+	ProxyProfile string         // Name of a proxy defined in config to fetch through
+	ProxyURL     SealedString   // Proxy to fetch through, including any credentials; takes precedence over ProxyProfile
+}
+
 type Subscription struct {
 	gorm.Model
-	UserID         uint
-	NotifierID     uint
-	Endpoint       string `gorm:"index:idx_endpoint_xpath"` // Composite index on endpoint & xpath
-	XPath          string `gorm:"index:idx_endpoint_xpath"`
+	UserID     uint
+	NotifierID uint
+	TargetID   uint `gorm:"index"`
+	WatchSpec
+
+	TitleOverride  string // Shown instead of the title found on the page, if set
+	Rules          Rules  // When set, changes are only notified if one of the rules fires
 	Title          string
 	ImageURL       string
 	LastPollTime   sql.NullTime
 	NoContentSince sql.NullTime
+	LastError      string // Why the last poll failed, e.g. the response was rejected; empty if it succeeded
+	LastHTTPStatus int    `gorm:"column:last_http_status"` // Status of the last response received, if any
+
+	Schedule   string       // Interval or cron expression to poll at, see ParseSchedule; empty polls at the default interval
+	Timezone   string       // IANA time zone that a cron schedule is evaluated in, UTC if empty
+	NextPollAt sql.NullTime `gorm:"index"` // When the subscription is next due to be polled; due immediately if null
+
+	MinInterval       time.Duration // Bounds the interval adapted to how often the content changes; zero uses the default
+	MaxInterval       time.Duration
+	EffectiveInterval time.Duration // Interval until the next poll when it was last scheduled
+	IntervalReason    string        // Why the next poll was scheduled at that interval
+
+	RecentDigests RecentDigests // Contents it recently changed away from, to tell when it flaps back to one of them
+
+	CacheValidators // From the response that its latest snapshot was taken from
+
+	ConsecutiveFailures int          // Failed polls since the last successful one
+	LastProxyError      string       // Failures of the proxy are tracked apart from failures of the endpoint itself
+	PausedAt            sql.NullTime `gorm:"index"` // Set when polling stopped after too many consecutive failures
 
 	Notifier Notifier
+	Target   Target
 }
 
 type Subscriptions []*Subscription
+
+// Selector returns the selector expression, with rows created before selector kinds existed treated as XPath.
+func (s *WatchSpec) Selector() (SelectorKind, string) {
+	if s.SelectorKind == "" {
+		return SelectorXPath, s.XPath
+	}
+	return s.SelectorKind, s.XPath
+}
+
+// Extraction returns what is taken from the matched nodes, defaulting to text for older rows.
+func (s *WatchSpec) Extraction() (ExtractMode, string) {
+	if s.ExtractMode == "" {
+		return ExtractText, s.ExtractAttr
+	}
+	return s.ExtractMode, s.ExtractAttr
+}
+
+// DisplayTitle returns the title to show the user for the subscription.
+func (s *Subscription) DisplayTitle() string {
+	if s.TitleOverride != "" {
+		return s.TitleOverride
+	}
+	return s.Title
+}
+
+// NextPoll returns when the subscription is next due, by its own schedule or else the default interval.
+func (s *Subscription) NextPoll(after time.Time, defaultInterval time.Duration) (time.Time, error) {
+	if s.Schedule == "" {
+		return after.Add(defaultInterval), nil
+	}
+	schedule, err := ParseSchedule(s.Schedule, s.Timezone)
+	if err != nil {
+		return time.Time{}, err
+	}
+	next := schedule.Next(after)
+	if next.IsZero() {
+		return next, ErrScheduleNeverFires
+	}
+	return next.UTC(), nil
+}
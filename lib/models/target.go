@@ -0,0 +1,48 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"gorm.io/gorm"
+)
+
+// Target is an endpoint watched with a particular spec, fetched once per poll for all its subscriptions.
+type Target struct {
+	gorm.Model
+	Key string `gorm:"uniqueIndex"` // Digest of the spec, see WatchSpec.Key
+	WatchSpec
+}
+
+// Key digests the spec, with defaults filled in, so that equivalent specs share a target. Credentials only go into
+// it through SecretDigest.
+func (s *WatchSpec) Key() string {
+	kind, expr := s.Selector()
+	mode, attr := s.Extraction()
+	method := s.Request.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	headers, secretHeaders := http.Header{}, http.Header{}
+	for name, values := range s.Request.Headers {
+		if IsSecretHeader(name) {
+			secretHeaders[name] = values
+		} else {
+			headers[name] = values
+		}
+	}
+
+	var secrets string
+	if len(secretHeaders) > 0 || s.Request.BasicPassword != "" || s.Request.BearerToken != "" || s.ProxyURL != "" {
+		canonical, _ := json.Marshal([]any{secretHeaders, s.Request.BasicPassword, s.Request.BearerToken, s.ProxyURL})
+		secrets = SecretDigest(canonical)
+	}
+	canonical, _ := json.Marshal([]any{
+		s.Endpoint, kind, expr, s.MultiNode, mode, attr,
+		method, headers, s.Request.Body, s.Request.BasicUser,
+		s.Charset, s.Normalize, s.ProxyProfile, secrets,
+	})
+	return fmt.Sprintf("%x", sha256.Sum256(canonical))
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestWatchSpecKey(t *testing.T) {
+	t.Cleanup(func() { SetSecretKey("") })
+	if err := SetSecretKey("test key"); err != nil {
+		t.Fatal(err)
+	}
+	spec := func(token string, headers Headers) WatchSpec {
+		return WatchSpec{
+			Endpoint:     "https://example.com/",
+			SelectorKind: SelectorCSS,
+			XPath:        "p",
+			Request:      RequestSpec{BearerToken: SealedString(token), Headers: headers},
+		}
+	}
+	key := func(s WatchSpec) string { return s.Key() }
+
+	if key(spec("", nil)) != key(spec("", nil)) {
+		t.Error("equivalent specs have different keys")
+	}
+	get := spec("", nil)
+	get.Request.Method = http.MethodGet
+	if key(get) != key(spec("", nil)) {
+		t.Error("a spec with the default method spelled out has another key")
+	}
+	if key(spec("one", nil)) == key(spec("two", nil)) {
+		t.Error("specs with different tokens share a key")
+	}
+	withAuth := func(value string) WatchSpec { return spec("", Headers{"Authorization": {value}}) }
+	if key(withAuth("Bearer one")) == key(withAuth("Bearer two")) {
+		t.Error("specs with different secret headers share a key")
+	}
+
+	// The key of a spec with credentials depends on the secret key, so it can't be used to guess them
+	before := key(spec("one", nil))
+	if err := SetSecretKey("another key"); err != nil {
+		t.Fatal(err)
+	}
+	if key(spec("one", nil)) == before {
+		t.Error("the key of a spec with a token doesn't depend on the secret key")
+	}
+
+	// Without credentials the key doesn't, so that targets are still shared without a secret key
+	public := spec("", Headers{"Accept-Language": {"en"}})
+	before = key(public)
+	if err := SetSecretKey(""); err != nil {
+		t.Fatal(err)
+	}
+	if key(public) != before {
+		t.Error("the key of a spec without credentials depends on the secret key")
+	}
+}
@@ -3,12 +3,79 @@ package models
 import (
 	"crypto/sha1"
 	"fmt"
+	"net/http"
+	"strings"
 )
 
 type EndpointContent struct {
 	Text     string
+	Items    Items       // Only set for multi-node subscriptions, in which case Text holds the items joined by newlines
+	Entries  FeedEntries // Only set for feed subscriptions, in which case Text lists the entries one per line
 	Title    string
 	ImageURL string
+
+	StatusCode int // HTTP status of the last response, also set when fetching the content failed
+
+	// Cache validators from the response, and whether the server confirmed that nothing changed since we last saw them.
+	CacheValidators
+	NotModified bool
+
+	Raw RawResponse // The response that the content was extracted from, archived with changed snapshots
+}
+
+// CacheValidators are the ETag and Last-Modified headers of a response, sent back to make the next request conditional.
+type CacheValidators struct {
+	ETag         string `gorm:"column:etag"`
+	LastModified string
+}
+
+// RawResponse is a response as the endpoint served it.
+type RawResponse struct {
+	Header http.Header
+	Body   []byte
+}
+
+// SetItems stores the matched items, skipping empty ones, and derives Text from them.
+func (c *EndpointContent) SetItems(items []string) {
+	c.Items = make(Items, 0, len(items))
+	for _, item := range items {
+		if item != "" {
+			c.Items = append(c.Items, item)
+		}
+	}
+	c.Text = strings.Join(c.Items, "\n")
+}
+
+// Normalize runs the steps over the content, treating the items of multi-node subscriptions as lines.
+func (c *EndpointContent) Normalize(steps NormalizeSteps) {
+	if len(steps) == 0 {
+		return
+	}
+	normalized := steps.Apply(c.Text)
+	if c.Items != nil {
+		c.SetItems(strings.Split(normalized, "\n"))
+	} else {
+		c.Text = normalized
+	}
+}
+
+// SetEntries stores the feed's entries, and derives Text from them.
+func (c *EndpointContent) SetEntries(entries FeedEntries) {
+	c.Entries = make(FeedEntries, 0, len(entries))
+	c.Entries = append(c.Entries, entries...)
+	lines := make([]string, len(entries))
+	for i, entry := range entries {
+		lines[i] = entry.String()
+	}
+	c.Text = strings.Join(lines, "\n")
+}
+
+// Digest fingerprints the content, the same way as a snapshot taken of it.
+func (c *EndpointContent) Digest() string {
+	if c.Entries != nil {
+		return c.Entries.Digest()
+	}
+	return DigestContent(c.Text)
 }
 
 func DigestContent(content string) string {
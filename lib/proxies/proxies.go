@@ -0,0 +1,55 @@
+// Package proxies routes individual requests of a shared transport through HTTP or SOCKS5 proxies.
+package proxies
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type contextKey struct{}
+
+// WithProxy returns a context whose requests go through proxyURL, or ctx itself if proxyURL is nil.
+func WithProxy(ctx context.Context, proxyURL *url.URL) context.Context {
+	if proxyURL == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, contextKey{}, proxyURL)
+}
+
+// FromRequest is an http.Transport.Proxy that uses the request context's proxy, if any. The environment's proxies
+// are ignored, as the transport can't vet the addresses that they connect to.
+func FromRequest(req *http.Request) (*url.URL, error) {
+	proxyURL, _ := req.Context().Value(contextKey{}).(*url.URL)
+	return proxyURL, nil
+}
+
+// Parse validates a proxy URL, which must use one of the http, https, socks5 or socks5h schemes.
+func Parse(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme: %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, errors.New("proxy URL has no host")
+	}
+	return u, nil
+}
+
+// IsProxyError reports whether a request failed because of the proxy rather than the target behind it.
+func IsProxyError(err error) bool {
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) {
+		return false
+	}
+	return opErr.Op == "proxyconnect" || strings.HasPrefix(opErr.Op, "socks")
+}
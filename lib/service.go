@@ -2,9 +2,15 @@ package lib
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"io"
+	"net/http"
+	"time"
 
 	"github.com/fiffu/diffwatch/config"
+	"github.com/fiffu/diffwatch/lib/blobs"
+	"github.com/fiffu/diffwatch/lib/diff"
 	"github.com/fiffu/diffwatch/lib/models"
 	"github.com/fiffu/diffwatch/lib/snapshotter"
 	"github.com/fiffu/diffwatch/senders"
@@ -18,15 +24,16 @@ type Service struct {
 	log     *zap.Logger
 	db      *gorm.DB
 	senders senders.Registry
+	archive *blobs.Store
 
 	snapshotter *snapshotter.Snapshotter
 	*onboardUser
 	*subscribe
 }
 
-func NewService(lc fx.Lifecycle, cfg *config.Config, log *zap.Logger, db *gorm.DB, snapshotter *snapshotter.Snapshotter, senders senders.Registry) *Service {
+func NewService(lc fx.Lifecycle, cfg *config.Config, log *zap.Logger, db *gorm.DB, snapshotter *snapshotter.Snapshotter, senders senders.Registry, archive *blobs.Store) *Service {
 	return &Service{
-		cfg, log, db, senders,
+		cfg, log, db, senders, archive,
 		snapshotter,
 		&onboardUser{cfg, log, db, senders},
 		&subscribe{cfg, log, db, snapshotter},
@@ -64,6 +71,97 @@ func (svc *Service) ListSubscriptions(ctx context.Context, userID uint, limit, o
 	return subs, nil
 }
 
+// ResumeSubscription resumes polling a subscription paused after repeated failures, from the next wakeup.
+func (svc *Service) ResumeSubscription(ctx context.Context, userID, subscriptionID uint) error {
+	tx := svc.db.Model(&models.Subscription{}).
+		Where("user_id = ?", userID).
+		Where("id = ?", subscriptionID).
+		Updates(map[string]any{
+			"paused_at":            nil,
+			"consecutive_failures": 0,
+			"next_poll_at":         nil,
+		})
+	if err := tx.Error; err != nil {
+		return err
+	}
+	if tx.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// SetNotifierDelivery changes when the user's notifier is told about changes, including those already pending.
+func (svc *Service) SetNotifierDelivery(ctx context.Context, userID uint, mode models.DeliveryMode, digestTime, timezone string) (*models.Notifier, error) {
+	notifier := &models.Notifier{}
+	tx := svc.db.Where("user_id = ?", userID).First(notifier)
+	if err := tx.Error; err != nil {
+		return nil, err
+	}
+
+	notifier.DeliveryMode, notifier.DigestTime, notifier.Timezone = mode, digestTime, timezone
+	schedule, err := notifier.DigestSchedule()
+	if err != nil {
+		return nil, err
+	}
+	updates := map[string]any{
+		"delivery_mode": mode,
+		"digest_time":   digestTime,
+		"timezone":      timezone,
+	}
+	if notifier.NextDigestAt.Valid {
+		next := time.Now().UTC()
+		if schedule != nil {
+			next = schedule.Next(next).UTC()
+		}
+		notifier.NextDigestAt.Time = next
+		updates["next_digest_at"] = next
+	}
+
+	tx = svc.db.Model(notifier).Updates(updates)
+	if err := tx.Error; err != nil {
+		return nil, err
+	}
+	return notifier, nil
+}
+
+// SnapshotterIntervals returns the poll and chase intervals in effect.
+func (svc *Service) SnapshotterIntervals(ctx context.Context) (snapshotter.Intervals, error) {
+	return svc.snapshotter.Intervals(ctx)
+}
+
+// SetSnapshotterIntervals saves the poll and chase intervals for every worker, leaving zero ones unchanged.
+func (svc *Service) SetSnapshotterIntervals(ctx context.Context, update snapshotter.Intervals) (snapshotter.Intervals, error) {
+	return svc.snapshotter.SetIntervals(ctx, update)
+}
+
+// PreviewNormalization fetches the content as extracted and as normalized by the given steps, or the subscription's.
+func (svc *Service) PreviewNormalization(ctx context.Context, userID, subscriptionID uint, steps models.NormalizeSteps) (raw, normalized *models.EndpointContent, err error) {
+	sub := models.Subscription{}
+	tx := svc.db.
+		Where("user_id = ?", userID).
+		Where("id = ?", subscriptionID).
+		First(&sub)
+	if err := tx.Error; err != nil {
+		return nil, nil, err
+	}
+	if steps == nil {
+		steps = sub.Normalize
+	}
+
+	// Fetch the full content, without normalizing it or revalidating our cached copy
+	target := &models.Target{WatchSpec: sub.WatchSpec}
+	target.Normalize = nil
+	raw, err = svc.snapshotter.GetEndpointContent(ctx, target, models.CacheValidators{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	normalized = &models.EndpointContent{}
+	*normalized = *raw
+	normalized.Normalize(steps)
+	return raw, normalized, nil
+}
+
 func (svc *Service) FindSnapshot(ctx context.Context, userID, subscriptionID uint) (*models.Snapshot, error) {
 	snap := &models.Snapshot{}
 	tx := svc.db.
@@ -77,6 +175,77 @@ func (svc *Service) FindSnapshot(ctx context.Context, userID, subscriptionID uin
 	return snap, nil
 }
 
+// ListSnapshots returns a page of the subscription's snapshots, latest first.
+func (svc *Service) ListSnapshots(ctx context.Context, userID, subscriptionID uint, limit, offset int) ([]*models.Snapshot, error) {
+	var snaps []*models.Snapshot
+	tx := svc.db.
+		Where("user_id = ?", userID).
+		Where("subscription_id = ?", subscriptionID).
+		Order("timestamp desc").
+		Limit(limit).Offset(offset).
+		Find(&snaps)
+	if err := tx.Error; err != nil {
+		return nil, err
+	}
+	return snaps, nil
+}
+
+// OpenArchive returns the raw response with the given body digest, archived with a subscription's snapshot.
+func (svc *Service) OpenArchive(ctx context.Context, userID, subscriptionID uint, digest string) (http.Header, io.ReadCloser, error) {
+	snap := &models.Snapshot{}
+	tx := svc.db.
+		Where("user_id = ?", userID).
+		Where("subscription_id = ?", subscriptionID).
+		Where("raw_body_digest = ?", digest).
+		First(snap)
+	if err := tx.Error; err != nil {
+		return nil, nil, err
+	}
+
+	headerBlob, err := svc.archive.Open(snap.RawHeaderDigest)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer headerBlob.Close()
+	header := http.Header{}
+	if err := json.NewDecoder(headerBlob).Decode(&header); err != nil {
+		return nil, nil, err
+	}
+
+	body, err := svc.archive.Open(snap.RawBodyDigest)
+	if err != nil {
+		return nil, nil, err
+	}
+	return header, body, nil
+}
+
+// FindSnapshotDiff returns the latest snapshot with its diff, computing it for snapshots stored without one.
+func (svc *Service) FindSnapshotDiff(ctx context.Context, userID, subscriptionID uint) (*models.Snapshot, diff.Hunks, error) {
+	var snaps models.Snapshots
+	tx := svc.db.
+		Where("user_id = ?", userID).
+		Where("subscription_id = ?", subscriptionID).
+		Order("timestamp desc").
+		Limit(2).
+		Find(&snaps)
+	if err := tx.Error; err != nil {
+		return nil, nil, err
+	}
+	if len(snaps) == 0 {
+		return nil, nil, gorm.ErrRecordNotFound
+	}
+
+	current := &snaps[0]
+	switch {
+	case current.Diff != nil:
+		return current, current.Diff, nil
+	case len(snaps) == 2:
+		return current, diff.Compute(snaps[1].Content, current.Content), nil
+	default:
+		return current, nil, nil
+	}
+}
+
 func (svc *Service) PushSnapshot(ctx context.Context, userID, subscriptionID uint) (*models.Snapshot, *models.Snapshot, error) {
 	sub := models.Subscription{}
 	tx := svc.db.
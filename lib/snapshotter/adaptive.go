@@ -0,0 +1,107 @@
+package snapshotter
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/fiffu/diffwatch/lib/models"
+)
+
+const (
+	// minAdaptiveHistory is how long a subscription is polled at the default interval before its polls adapt.
+	minAdaptiveHistory = 24 * time.Hour
+
+	// pollsPerChange is how many times a subscription is polled in the time it is expected to take to change.
+	pollsPerChange = 2
+)
+
+// pollBounds limit the interval that a subscription without a schedule adapts within.
+type pollBounds struct {
+	min, max time.Duration
+}
+
+// PollPlan is when a subscription is next polled, and why.
+type PollPlan struct {
+	At       time.Time
+	Interval time.Duration
+	Reason   string
+}
+
+// PlanNextPoll decides when the subscription is next due, by its schedule, adapted to its changes within its bounds,
+// or else at the poll interval.
+func (s *Snapshotter) PlanNextPoll(ctx context.Context, sub *models.Subscription, after time.Time) (PollPlan, error) {
+	if sub.Schedule != "" {
+		next, err := sub.NextPoll(after, s.pollInterval())
+		if err != nil {
+			return PollPlan{}, err
+		}
+		return PollPlan{next, next.Sub(after).Round(time.Second), "follows its schedule"}, nil
+	}
+	bounds, ok := s.boundsOf(sub)
+	if !ok {
+		interval := s.pollInterval()
+		return PollPlan{after.Add(interval), interval, "polled at the default interval"}, nil
+	}
+
+	// Flaps back to earlier content aren't changes of their own
+	var captures []time.Time
+	tx := s.db.Model(&models.Snapshot{}).
+		Where("subscription_id = ? AND captured_at IS NOT NULL AND NOT reverted", sub.ID).
+		Order("captured_at").
+		Pluck("captured_at", &captures)
+	if err := tx.Error; err != nil {
+		return PollPlan{}, err
+	}
+
+	interval, reason := adaptInterval(captures, after, s.pollInterval(), bounds)
+	return PollPlan{after.Add(interval), interval, reason}, nil
+}
+
+// boundsOf returns the bounds the subscription's interval adapts within, or false if it set none.
+func (s *Snapshotter) boundsOf(sub *models.Subscription) (pollBounds, bool) {
+	if sub.MinInterval == 0 && sub.MaxInterval == 0 {
+		return pollBounds{}, false
+	}
+	// A bound left unset is the poll interval, unless the other bound lies beyond it
+	poll := s.pollInterval()
+	return pollBounds{
+		min: cmp.Or(sub.MinInterval, min(poll, sub.MaxInterval)),
+		max: cmp.Or(sub.MaxInterval, max(poll, sub.MinInterval)),
+	}, true
+}
+
+// adaptInterval returns the interval to poll at, and why, from the times the content's versions were captured.
+func adaptInterval(captures []time.Time, now time.Time, fallback time.Duration, bounds pollBounds) (time.Duration, string) {
+	var interval time.Duration
+	var reason string
+	if len(captures) == 0 || now.Sub(captures[0]) < minAdaptiveHistory {
+		interval, reason = fallback, "not enough history yet, using the default interval"
+	} else {
+		watched := now.Sub(captures[0])
+		changes := len(captures) - 1
+		if changes == 0 {
+			interval = roundDuration(watched / pollsPerChange)
+			reason = fmt.Sprintf("unchanged in %s", roundDuration(watched))
+		} else {
+			every := watched / time.Duration(changes)
+			interval = roundDuration(every / pollsPerChange)
+			reason = fmt.Sprintf("changed %d times in %s, about every %s", changes, roundDuration(watched), roundDuration(every))
+		}
+	}
+
+	switch {
+	case interval < bounds.min:
+		interval = bounds.min
+		reason += ", limited by the minimum interval"
+	case interval > bounds.max:
+		interval = bounds.max
+		reason += ", limited by the maximum interval"
+	}
+	return interval, reason
+}
+
+func roundDuration(d time.Duration) time.Duration {
+	return d.Round(time.Minute)
+}
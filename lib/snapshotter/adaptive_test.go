@@ -0,0 +1,108 @@
+package snapshotter
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/fiffu/diffwatch/lib/models"
+)
+
+func TestAdaptInterval(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	ago := func(d time.Duration) time.Time { return now.Add(-d) }
+	bounds := pollBounds{15 * time.Minute, 24 * time.Hour}
+
+	tests := []struct {
+		name     string
+		captures []time.Time
+		want     time.Duration
+	}{
+		{"no history", nil, time.Hour},
+		{"less than a day of history", []time.Time{ago(12 * time.Hour)}, time.Hour},
+		{"unchanged for two days", []time.Time{ago(48 * time.Hour)}, 24 * time.Hour},
+		{"changed every 8 hours", []time.Time{ago(24 * time.Hour), ago(16 * time.Hour), ago(12 * time.Hour), ago(4 * time.Hour)}, 4 * time.Hour},
+		{"unchanged for longer than the maximum", []time.Time{ago(30 * 24 * time.Hour)}, 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reason := adaptInterval(tt.captures, now, time.Hour, bounds)
+			if got != tt.want {
+				t.Errorf("adaptInterval() = %s (%s), want %s", got, reason, tt.want)
+			}
+		})
+	}
+
+	t.Run("limited by the bounds", func(t *testing.T) {
+		bounds := pollBounds{10 * time.Hour, 24 * time.Hour}
+		captures := []time.Time{ago(48 * time.Hour), ago(47 * time.Hour), ago(46 * time.Hour), ago(45 * time.Hour)}
+		if got, _ := adaptInterval(captures, now, time.Hour, bounds); got != bounds.min {
+			t.Errorf("adaptInterval() = %s, want the minimum %s", got, bounds.min)
+		}
+		if got, _ := adaptInterval(nil, now, 48*time.Hour, bounds); got != bounds.max {
+			t.Errorf("adaptInterval() = %s, want the maximum %s", got, bounds.max)
+		}
+	})
+}
+
+func TestPlanNextPoll(t *testing.T) {
+	s, _ := newTestSnapshotter(t)
+	_, srv := newPage(t, "two")
+	now := time.Now().UTC()
+
+	// Changed every 4 hours over the last two days, and flapped back and forth in the last minutes
+	sub := subscribe(t, s, srv.URL, "one")
+	for i := 0; i <= 12; i++ {
+		at := now.Add(-time.Duration(48-4*i) * time.Hour)
+		snap := &models.Snapshot{Timestamp: at, CapturedAt: sql.NullTime{Time: at, Valid: true}, SubscriptionID: sub.ID, Content: string(rune('a' + i))}
+		if err := s.db.Create(snap).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 1; i <= 10; i++ {
+		at := now.Add(-time.Duration(i) * time.Minute)
+		snap := &models.Snapshot{Timestamp: at, CapturedAt: sql.NullTime{Time: at, Valid: true}, SubscriptionID: sub.ID, Content: "flap", Reverted: true}
+		if err := s.db.Create(snap).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		min, max time.Duration
+		want     time.Duration
+	}{
+		{"without bounds at the poll interval", 0, 0, time.Hour},
+		{"adapted within the bounds", 30 * time.Minute, 12 * time.Hour, 2 * time.Hour},
+		{"up to the poll interval without a maximum", 30 * time.Minute, 0, time.Hour},
+		{"down to the poll interval without a minimum", 0, 12 * time.Hour, 2 * time.Hour},
+		{"at least a minimum above the poll interval", 3 * time.Hour, 0, 3 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub.MinInterval, sub.MaxInterval = tt.min, tt.max
+			plan, err := s.PlanNextPoll(context.Background(), sub, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if plan.Interval != tt.want || !plan.At.Equal(now.Add(tt.want)) {
+				t.Errorf("PlanNextPoll() = %+v, want an interval of %s", plan, tt.want)
+			}
+		})
+	}
+
+	t.Run("follows a new poll interval", func(t *testing.T) {
+		sub.MinInterval, sub.MaxInterval = 0, 0
+		if _, err := s.SetIntervals(context.Background(), Intervals{Poll: 2 * time.Hour, Chase: time.Minute}); err != nil {
+			t.Fatal(err)
+		}
+		plan, err := s.PlanNextPoll(context.Background(), sub, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plan.Interval != 2*time.Hour {
+			t.Errorf("PlanNextPoll() = %+v, want the new poll interval", plan)
+		}
+	})
+}
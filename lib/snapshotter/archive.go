@@ -0,0 +1,57 @@
+package snapshotter
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/fiffu/diffwatch/lib/models"
+)
+
+// archiveGracePeriod is how long an unreferenced blob is kept, as its snapshot may be about to be saved.
+const archiveGracePeriod = time.Hour
+
+// ArchiveRaw stores, on a best effort basis, the response that the snapshot's content was extracted from.
+func (s *Snapshotter) ArchiveRaw(snap *models.Snapshot, raw models.RawResponse) {
+	if raw.Body == nil {
+		return
+	}
+	header, err := json.Marshal(raw.Header)
+	if err != nil {
+		s.log.Sugar().Errorw("Failed to encode response headers for archive", "subscription_id", snap.SubscriptionID, "err", err)
+		return
+	}
+	headerDigest, err := s.archive.Put(header)
+	if err != nil {
+		s.log.Sugar().Errorw("Failed to archive response headers", "subscription_id", snap.SubscriptionID, "err", err)
+		return
+	}
+	bodyDigest, err := s.archive.Put(raw.Body)
+	if err != nil {
+		s.log.Sugar().Errorw("Failed to archive response body", "subscription_id", snap.SubscriptionID, "err", err)
+		return
+	}
+	snap.RawBodyDigest, snap.RawHeaderDigest = bodyDigest, headerDigest
+}
+
+// sweepArchive removes the archived responses that no snapshot refers to anymore, as their snapshots were purged.
+func (s *Snapshotter) sweepArchive(now time.Time) {
+	var digests []string
+	tx := s.db.Raw(`SELECT raw_body_digest FROM snapshots WHERE raw_body_digest <> ''
+		UNION SELECT raw_header_digest FROM snapshots WHERE raw_header_digest <> ''`).Scan(&digests)
+	if err := tx.Error; err != nil {
+		s.log.Sugar().Errorw("Failed to list archived responses in use", "err", err)
+		return
+	}
+	referenced := make(map[string]bool, len(digests))
+	for _, digest := range digests {
+		referenced[digest] = true
+	}
+
+	removed, err := s.archive.Sweep(referenced, now.Add(-archiveGracePeriod))
+	if err != nil {
+		s.log.Sugar().Errorw("Failed to sweep archive", "err", err)
+	}
+	if removed > 0 {
+		s.log.Sugar().Infof("Removed %d archived blobs", removed)
+	}
+}
@@ -0,0 +1,47 @@
+package snapshotter
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/fiffu/diffwatch/lib/models"
+)
+
+func TestArchiveRaw(t *testing.T) {
+	s, _ := newTestSnapshotter(t)
+	_, srv := newPage(t, "two")
+	sub := subscribe(t, s, srv.URL, "one")
+	runAgain(t, s)
+
+	var snap models.Snapshot
+	if err := s.db.Where("subscription_id = ? AND content = ?", sub.ID, "two").First(&snap).Error; err != nil {
+		t.Fatal(err)
+	}
+	r, err := s.archive.Open(snap.RawBodyDigest)
+	if err != nil {
+		t.Fatalf("changed snapshot has no archived body: %v", err)
+	}
+	body, err := io.ReadAll(r)
+	r.Close()
+	if want := "<html><body><p>two</p></body></html>"; err != nil || string(body) != want {
+		t.Errorf("archived body = %q, %v; want %q", body, err, want)
+	}
+
+	// Blobs are kept while a snapshot refers to them
+	later := time.Now().Add(2 * archiveGracePeriod)
+	s.sweepArchive(later)
+	if _, err := s.archive.Open(snap.RawHeaderDigest); err != nil {
+		t.Errorf("archived headers of a kept snapshot were swept: %v", err)
+	}
+
+	if err := s.db.Where("raw_body_digest = ?", snap.RawBodyDigest).Delete(&models.Snapshot{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	s.sweepArchive(later)
+	for _, digest := range []string{snap.RawBodyDigest, snap.RawHeaderDigest} {
+		if _, err := s.archive.Open(digest); err == nil {
+			t.Errorf("blob %s of a purged snapshot wasn't swept", digest)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package snapshotter
+
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+)
+
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// decodeBody transcodes a body to UTF-8 from the override, BOM, Content-Type or <meta> charset, as browsers do.
+func decodeBody(body []byte, contentType, override string, isJSON bool) ([]byte, error) {
+	var enc encoding.Encoding
+	var name string
+
+	if override != "" {
+		if enc, name = charset.Lookup(override); enc == nil {
+			return nil, fmt.Errorf("unknown charset: %s", override)
+		}
+	} else {
+		var certain bool
+		enc, name, certain = charset.DetermineEncoding(body, contentType)
+		if !certain && (isJSON || (name == "windows-1252" && utf8.Valid(body))) {
+			// DetermineEncoding only inspects the first 1024 bytes, and guesses windows-1252 if they are all ASCII.
+			name = "utf-8"
+		}
+	}
+
+	if name != "utf-8" {
+		decoded, err := enc.NewDecoder().Bytes(body)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode body as %s: %w", name, err)
+		}
+		body = decoded
+	}
+	return bytes.TrimPrefix(body, utf8BOM), nil
+}
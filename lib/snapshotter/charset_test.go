@@ -0,0 +1,46 @@
+package snapshotter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDecodeBody(t *testing.T) {
+	latin1 := "caf\xe9"
+	sjis := "\x93\xfa\x96\x7b" // 日本
+	tests := []struct {
+		name        string
+		body        string
+		contentType string
+		override    string
+		isJSON      bool
+		want        string
+	}{
+		{"utf-8 header", "café", "text/html; charset=utf-8", "", false, "café"},
+		{"latin-1 header", latin1, "text/html; charset=iso-8859-1", "", false, "café"},
+		{"shift_jis header", sjis, "text/html; charset=Shift_JIS", "", false, "日本"},
+		{"meta charset", `<meta charset="iso-8859-1"><p>` + latin1, "text/html", "", false, `<meta charset="iso-8859-1"><p>café`},
+		{"meta http-equiv", `<meta http-equiv="Content-Type" content="text/html; charset=shift_jis">` + sjis, "text/html", "", false,
+			`<meta http-equiv="Content-Type" content="text/html; charset=shift_jis">日本`},
+		{"bom wins over header", "\xef\xbb\xbfcafé", "text/html; charset=iso-8859-1", "", false, "café"},
+		{"undeclared utf-8", "<p>" + strings.Repeat("a", 2000) + "café", "text/html", "", false, "<p>" + strings.Repeat("a", 2000) + "café"},
+		{"undeclared latin-1", "<p>" + latin1, "text/html", "", false, "<p>café"},
+		{"json", `{"name": "café"}`, "application/json", "", true, `{"name": "café"}`},
+		{"override", latin1, "text/html; charset=utf-8", "latin1", false, "café"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeBody([]byte(tt.body), tt.contentType, tt.override, tt.isJSON)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("decodeBody() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := decodeBody([]byte("x"), "text/html", "klingon", false); err == nil {
+		t.Error("decodeBody() with an unknown charset succeeded, want an error")
+	}
+}
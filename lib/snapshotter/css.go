@@ -0,0 +1,38 @@
+package snapshotter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+)
+
+// CSSSelector is a compiled CSS selector group, e.g. "#main > p.lead, li:nth-child(odd)".
+type CSSSelector struct {
+	expr  string
+	group cascadia.SelectorGroup
+}
+
+// CompileCSS parses a CSS selector expression.
+func CompileCSS(expr string) (*CSSSelector, error) {
+	group, err := cascadia.ParseGroup(strings.TrimSpace(expr))
+	if err != nil {
+		return nil, fmt.Errorf("invalid css selector %q: %w", expr, err)
+	}
+	return &CSSSelector{expr, group}, nil
+}
+
+func (sel *CSSSelector) String() string {
+	return sel.expr
+}
+
+// MatchAll returns every element under root that matches the selector, in document order.
+func (sel *CSSSelector) MatchAll(root *html.Node) []*html.Node {
+	return append(make([]*html.Node, 0), cascadia.QueryAll(root, sel.group)...)
+}
+
+// MatchOne returns the first element under root that matches the selector, or nil.
+func (sel *CSSSelector) MatchOne(root *html.Node) *html.Node {
+	return cascadia.Query(root, sel.group)
+}
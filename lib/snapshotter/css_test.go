@@ -0,0 +1,140 @@
+package snapshotter
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+const cssDoc = `<html><body>
+<div id="main" class="box wide">
+	<h1>Title</h1>
+	<p id="p1" class="lead" lang="en-GB">One</p>
+	<p id="p2" data-kind="Primary Note">Two</p>
+	<span id="s1"></span>
+	<p id="p3" title="a.pdf">Three</p>
+	<ul id="list">
+		<li id="l1">a</li><li id="l2">b</li><li id="l3">c</li><li id="l4">d</li><li id="l5">e</li>
+	</ul>
+</div>
+<div id="aside"><p id="p4">Four</p></div>
+<a id="odd.id" href="https://example.com/x"></a>
+</body></html>`
+
+func TestCSSSelectorMatchAll(t *testing.T) {
+	root, err := html.Parse(strings.NewReader(cssDoc))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		expr string
+		want []string // ids of the matched elements
+	}{
+		// Type, universal, id and class
+		{"p", []string{"p1", "p2", "p3", "p4"}},
+		{"#main", []string{"main"}},
+		{"div.box.wide", []string{"main"}},
+		{".box.narrow", []string{}},
+		{"ul > *", []string{"l1", "l2", "l3", "l4", "l5"}},
+		{`#odd\.id`, []string{`odd.id`}},
+		{"P#P1", []string{}}, // ids are case-sensitive, tags are not
+		{"P#p1", []string{"p1"}},
+
+		// Combinators
+		{"div p", []string{"p1", "p2", "p3", "p4"}},
+		{"#main > p", []string{"p1", "p2", "p3"}},
+		{"body > p", []string{}},
+		{"h1 + p", []string{"p1"}},
+		{"span + p", []string{"p3"}},
+		{"h1 ~ p", []string{"p1", "p2", "p3"}},
+		{"span ~ *", []string{"p3", "list"}},
+		{"#main>ul>li+li", []string{"l2", "l3", "l4", "l5"}},
+		{"#p4, #p1", []string{"p1", "p4"}},
+
+		// Attribute operators
+		{"[lang]", []string{"p1"}},
+		{"[lang=en-GB]", []string{"p1"}},
+		{"[lang|=en]", []string{"p1"}},
+		{"[lang|=e]", []string{}},
+		{`[data-kind~="Note"]`, []string{"p2"}},
+		{"[data-kind~=note]", []string{}},
+		{"[data-kind~=note i]", []string{"p2"}},
+		{"[href^='https://']", []string{"odd.id"}},
+		{"[title$='.pdf']", []string{"p3"}},
+		{`[data-kind*="ary N"]`, []string{"p2"}},
+
+		// Pseudo-classes
+		{"li:first-child", []string{"l1"}},
+		{"li:last-child", []string{"l5"}},
+		{"li:nth-child(2)", []string{"l2"}},
+		{"li:nth-child(odd)", []string{"l1", "l3", "l5"}},
+		{"li:nth-child(even)", []string{"l2", "l4"}},
+		{"li:nth-child(2n+3)", []string{"l3", "l5"}},
+		{"li:nth-child(-n+2)", []string{"l1", "l2"}},
+		{"li:nth-last-child(1)", []string{"l5"}},
+		{"#main > p:nth-of-type(2)", []string{"p2"}},
+		{"#main > p:nth-last-of-type(1)", []string{"p3"}},
+		{"#main > p:first-of-type", []string{"p1"}},
+		{"#main > p:last-of-type", []string{"p3"}},
+		{"p:only-child", []string{"p4"}},
+		{"span:empty", []string{"s1"}},
+		{"#main > p:not(.lead)", []string{"p2", "p3"}},
+		{"li:not(:first-child):not(:last-child)", []string{"l2", "l3", "l4"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			sel, err := CompileCSS(tt.expr)
+			if err != nil {
+				t.Fatalf("CompileCSS(%q): %v", tt.expr, err)
+			}
+			got := make([]string, 0)
+			for _, n := range sel.MatchAll(root) {
+				for _, a := range n.Attr {
+					if a.Key == "id" {
+						got = append(got, a.Val)
+					}
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MatchAll(%q) = %q, want %q", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileCSSRejectsInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		`\`,
+		`p\`,
+		`#\`,
+		`[lang=\]`,
+		"#",
+		".",
+		"p,",
+		",p",
+		"p >",
+		"> p",
+		"p > > p",
+		"p!",
+		"[",
+		"[lang",
+		"[lang==x]",
+		"[lang=x",
+		"[lang='x]",
+		"p:frobnicate",
+		"li:nth-child(",
+		"li:nth-child(x)",
+		"li:nth-child(2n+x)",
+		"p:not(#)",
+	} {
+		t.Run(expr, func(t *testing.T) {
+			if _, err := CompileCSS(expr); err == nil {
+				t.Errorf("CompileCSS(%q) succeeded, want an error", expr)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package snapshotter
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/fiffu/diffwatch/lib/models"
+	"gorm.io/gorm"
+)
+
+// notify tells the subscriber about a change, right away or in their next digest.
+func (s *Snapshotter) notify(ctx context.Context, sub *models.Subscription, prev, curr *models.Snapshot) error {
+	schedule, err := sub.Notifier.DigestSchedule()
+	if err != nil {
+		s.log.Sugar().Errorw("Invalid digest settings, notifying immediately", "notifier_id", sub.NotifierID, "err", err)
+	}
+	if schedule == nil {
+		return s.SendSnapshot(ctx, sub, prev, curr)
+	}
+	return s.queueNotification(ctx, sub, prev, curr, schedule.Next(time.Now().UTC()).UTC())
+}
+
+// queueNotification adds a change to the notifier's next digest, due at nextDigest at the latest.
+func (s *Snapshotter) queueNotification(ctx context.Context, sub *models.Subscription, prev, curr *models.Snapshot, nextDigest time.Time) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		pending := models.PendingNotification{
+			NotifierID:     sub.NotifierID,
+			SubscriptionID: sub.ID,
+			Change:         models.Change{Previous: prev, Current: curr},
+		}
+		if err := tx.Create(&pending).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.Notifier{}).
+			Where("id = ?", sub.NotifierID).
+			Where("next_digest_at IS NULL").
+			Update("next_digest_at", nextDigest).Error
+	})
+}
+
+// sendDueDigests sends the digest of every notifier that is due, until the snapshotter is stopping.
+func (s *Snapshotter) sendDueDigests(stopping context.Context, now time.Time) {
+	var notifiers []*models.Notifier
+	tx := s.db.Where("next_digest_at <= ?", now).Find(&notifiers)
+	if err := tx.Error; err != nil {
+		s.log.Sugar().Errorw("Failed to find due digests", "err", err)
+		return
+	}
+	for _, notifier := range notifiers {
+		if stopping.Err() != nil {
+			return
+		}
+		if err := s.sendDigest(notifier); err != nil {
+			s.log.Sugar().Errorw("Failed to send digest", "notifier_id", notifier.ID, "err", err)
+		}
+	}
+}
+
+// sendDigest claims and sends the notifier's digest, making it due again if it can't be sent.
+func (s *Snapshotter) sendDigest(notifier *models.Notifier) error {
+	due := notifier.NextDigestAt
+	tx := s.db.Model(&models.Notifier{}).
+		Where("id = ? AND next_digest_at = ?", notifier.ID, due).
+		Update("next_digest_at", nil)
+	if err := tx.Error; err != nil || tx.RowsAffected == 0 {
+		return err
+	}
+
+	var pending models.PendingNotifications
+	tx = s.db.Where("notifier_id = ?", notifier.ID).Preload("Subscription").Order("id").Find(&pending)
+	if err := tx.Error; err != nil {
+		s.rescheduleDigest(notifier, due)
+		return err
+	}
+
+	// Changes to subscriptions deleted since are dropped
+	ids := make([]uint, len(pending))
+	var digest models.PendingNotifications
+	for i, p := range pending {
+		ids[i] = p.ID
+		if p.Subscription.ID != 0 {
+			digest = append(digest, p)
+		}
+	}
+
+	if len(digest) > 0 {
+		sender, ok := s.senders[notifier.Platform]
+		if !ok {
+			// Kept for when the platform is configured again
+			s.rescheduleDigest(notifier, due)
+			return fmt.Errorf("unsupported notifier platform: %s", notifier.Platform)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), s.jobTimeout)
+		defer cancel()
+		if _, err := sender.SendDigest(ctx, notifier, digest); err != nil {
+			s.rescheduleDigest(notifier, due)
+			return err
+		}
+		s.log.Sugar().Infow("Sent digest", "notifier_id", notifier.ID, "changes", len(digest))
+	}
+
+	tx = s.db.Where("id IN ?", ids).Delete(&models.PendingNotification{})
+	return tx.Error
+}
+
+// rescheduleDigest makes a digest that couldn't be sent due again at the given time.
+func (s *Snapshotter) rescheduleDigest(notifier *models.Notifier, due sql.NullTime) {
+	tx := s.db.Model(&models.Notifier{}).Where("id = ?", notifier.ID).Update("next_digest_at", due)
+	if err := tx.Error; err != nil {
+		s.log.Sugar().Errorw("Failed to reschedule digest", "notifier_id", notifier.ID, "err", err)
+	}
+}
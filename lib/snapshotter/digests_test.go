@@ -0,0 +1,120 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fiffu/diffwatch/lib/models"
+)
+
+// digestNotifier makes the subscription's notifier send hourly digests.
+func digestNotifier(t *testing.T, s *Snapshotter, sub *models.Subscription) {
+	tx := s.db.Model(&models.Notifier{}).Where("id = ?", sub.NotifierID).Update("delivery_mode", models.DeliverHourly)
+	if err := tx.Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// makeDigestDue brings the notifier's next digest forward to now.
+func makeDigestDue(t *testing.T, s *Snapshotter, notifierID uint) {
+	tx := s.db.Model(&models.Notifier{}).Where("id = ? AND next_digest_at IS NOT NULL", notifierID).Update("next_digest_at", time.Now().UTC())
+	if err := tx.Error; err != nil || tx.RowsAffected != 1 {
+		t.Fatalf("no digest to make due: %v", err)
+	}
+}
+
+func pendingCount(t *testing.T, s *Snapshotter) int64 {
+	var n int64
+	if err := s.db.Model(&models.PendingNotification{}).Count(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestDigests(t *testing.T) {
+	s, sender := newTestSnapshotter(t)
+	_, srv := newPage(t, "two")
+	sub := subscribe(t, s, srv.URL, "one")
+	digestNotifier(t, s, sub)
+
+	before := time.Now().UTC()
+	runAgain(t, s)
+
+	if got := sender.sentTo(sub); len(got) != 0 {
+		t.Errorf("change was sent right away as %q, want it queued", got)
+	}
+	if n := pendingCount(t, s); n != 1 {
+		t.Fatalf("%d changes queued, want 1", n)
+	}
+	var notifier models.Notifier
+	if err := s.db.First(&notifier, sub.NotifierID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if at := notifier.NextDigestAt.Time; !notifier.NextDigestAt.Valid || !at.After(before) || at.Sub(before) > time.Hour || at.Minute() != 0 {
+		t.Errorf("next digest at %v, want the top of the next hour", notifier.NextDigestAt)
+	}
+
+	s.sendDueDigests(context.Background(), time.Now().UTC())
+	if got := sender.digestsTo(sub.NotifierID); len(got) != 0 {
+		t.Errorf("digest sent before it was due: %q", got)
+	}
+
+	makeDigestDue(t, s, sub.NotifierID)
+	s.sendDueDigests(context.Background(), time.Now().UTC())
+	if got := sender.digestsTo(sub.NotifierID); len(got) != 1 || len(got[0]) != 1 || got[0][0] != "two" {
+		t.Errorf("digests sent = %q, want [[two]]", got)
+	}
+	if n := pendingCount(t, s); n != 0 {
+		t.Errorf("%d changes still queued after the digest was sent", n)
+	}
+	var sent models.Notifier
+	if err := s.db.First(&sent, sub.NotifierID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if sent.NextDigestAt.Valid {
+		t.Errorf("next digest at %v, want none without changes queued", sent.NextDigestAt.Time)
+	}
+}
+
+func TestDigestsRetryFailedSends(t *testing.T) {
+	s, sender := newTestSnapshotter(t)
+	_, srv := newPage(t, "two")
+	sub := subscribe(t, s, srv.URL, "one")
+	digestNotifier(t, s, sub)
+	runAgain(t, s)
+	makeDigestDue(t, s, sub.NotifierID)
+
+	sender.digestErr = errors.New("unavailable")
+	s.sendDueDigests(context.Background(), time.Now().UTC())
+	if n := pendingCount(t, s); n != 1 {
+		t.Fatalf("%d changes queued after a failed send, want 1", n)
+	}
+
+	sender.digestErr = nil
+	s.sendDueDigests(context.Background(), time.Now().UTC())
+	if got := sender.digestsTo(sub.NotifierID); len(got) != 1 {
+		t.Errorf("digests sent = %q, want the one that failed", got)
+	}
+}
+
+func TestDigestsDropDeletedSubscriptions(t *testing.T) {
+	s, sender := newTestSnapshotter(t)
+	_, srv := newPage(t, "two")
+	sub := subscribe(t, s, srv.URL, "one")
+	digestNotifier(t, s, sub)
+	runAgain(t, s)
+	makeDigestDue(t, s, sub.NotifierID)
+
+	if err := s.db.Delete(sub).Error; err != nil {
+		t.Fatal(err)
+	}
+	s.sendDueDigests(context.Background(), time.Now().UTC())
+	if got := sender.digestsTo(sub.NotifierID); len(got) != 0 {
+		t.Errorf("digests sent = %q, want none for a deleted subscription", got)
+	}
+	if n := pendingCount(t, s); n != 0 {
+		t.Errorf("%d changes of a deleted subscription still queued", n)
+	}
+}
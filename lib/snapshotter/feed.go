@@ -0,0 +1,208 @@
+package snapshotter
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/fiffu/diffwatch/lib/models"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+// Feed is an RSS or Atom feed.
+type Feed struct {
+	Title    string
+	ImageURL string
+	Entries  models.FeedEntries
+}
+
+type rssDocument struct {
+	Channel rssChannel `xml:"channel"`
+	Items   []rssItem  `xml:"item"` // RSS 1.0 puts items beside the channel, rather than inside it
+}
+
+type rssChannel struct {
+	Title string    `xml:"title"`
+	Image rssImage  `xml:"image"`
+	Items []rssItem `xml:"item"`
+}
+
+type rssImage struct {
+	URL string `xml:"url"`
+}
+
+type rssItem struct {
+	About       string   `xml:"http://www.w3.org/1999/02/22-rdf-syntax-ns# about,attr"`
+	GUID        string   `xml:"guid"`
+	Title       string   `xml:"title"`
+	Links       []string `xml:"link"`
+	Description string   `xml:"description"`
+	Content     string   `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+}
+
+type atomFeed struct {
+	Title   atomText    `xml:"title"`
+	Icon    string      `xml:"icon"`
+	Logo    string      `xml:"logo"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	ID      string     `xml:"id"`
+	Title   atomText   `xml:"title"`
+	Links   []atomLink `xml:"link"`
+	Summary atomText   `xml:"summary"`
+	Content atomText   `xml:"content"`
+}
+
+// atomText holds the raw markup of a text construct, since those of type xhtml are made of child elements.
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",innerxml"`
+}
+
+var cdataSection = regexp.MustCompile(`(?s)<!\[CDATA\[(.*?)\]\]>`)
+
+// Text returns the text construct with any markup stripped.
+func (t atomText) Text() string {
+	s := cdataSection.ReplaceAllStringFunc(t.Body, func(section string) string {
+		return html.EscapeString(cdataSection.FindStringSubmatch(section)[1])
+	})
+	if t.Type == "html" {
+		s = html.UnescapeString(s) // The markup is escaped
+	}
+	doc, err := html.Parse(strings.NewReader(s))
+	if err != nil {
+		return compactWhitespace(s)
+	}
+	return digForText(doc)
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+}
+
+// ParseFeed parses an RSS or Atom feed, resolving relative links against base.
+func ParseFeed(body []byte, contentType, override string, base *url.URL) (*Feed, error) {
+	d := xml.NewDecoder(bytes.NewReader(body))
+	d.CharsetReader = charset.NewReaderLabel
+	if _, params, _ := mime.ParseMediaType(contentType); override != "" || params["charset"] != "" {
+		decoded, err := decodeBody(body, contentType, override, false)
+		if err != nil {
+			return nil, err
+		}
+		d = xml.NewDecoder(bytes.NewReader(decoded))
+		d.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+			return input, nil // Already transcoded to UTF-8, whatever the XML declaration says
+		}
+	}
+	// Feeds in the wild are often sloppy XML, with HTML entities and stray ampersands
+	d.Strict = false
+	d.Entity = xml.HTMLEntity
+
+	root, err := feedRoot(d)
+	if err != nil {
+		return nil, err
+	}
+
+	feed := &Feed{}
+	switch root.Name.Local {
+	case "rss", "RDF":
+		var doc rssDocument
+		if err := d.DecodeElement(&doc, &root); err != nil {
+			return nil, fmt.Errorf("unable to parse RSS feed: %w", err)
+		}
+		feed.Title = compactWhitespace(doc.Channel.Title)
+		feed.ImageURL = resolveLink(base, doc.Channel.Image.URL)
+		for _, item := range append(doc.Channel.Items, doc.Items...) {
+			feed.Entries = append(feed.Entries, item.entry(base))
+		}
+
+	case "feed":
+		var doc atomFeed
+		if err := d.DecodeElement(&doc, &root); err != nil {
+			return nil, fmt.Errorf("unable to parse Atom feed: %w", err)
+		}
+		feed.Title = doc.Title.Text()
+		feed.ImageURL = resolveLink(base, cmp.Or(doc.Logo, doc.Icon))
+		for _, entry := range doc.Entries {
+			feed.Entries = append(feed.Entries, entry.entry(base))
+		}
+
+	default:
+		return nil, fmt.Errorf("not an RSS or Atom feed: unexpected root element <%s>", root.Name.Local)
+	}
+	return feed, nil
+}
+
+// feedRoot skips ahead to the document's root element.
+func feedRoot(d *xml.Decoder) (xml.StartElement, error) {
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return xml.StartElement{}, errors.New("not an RSS or Atom feed: document is empty")
+		}
+		if err != nil {
+			return xml.StartElement{}, fmt.Errorf("unable to parse feed: %w", err)
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			return start, nil
+		}
+	}
+}
+
+func (item rssItem) entry(base *url.URL) models.FeedEntry {
+	var link string
+	for _, l := range item.Links {
+		// Items may carry atom:link elements too, which have no text
+		if l = strings.TrimSpace(l); l != "" {
+			link = resolveLink(base, l)
+			break
+		}
+	}
+	body := cmp.Or(item.Content, item.Description)
+	return newFeedEntry(cmp.Or(strings.TrimSpace(item.GUID), item.About), compactWhitespace(item.Title), link, body)
+}
+
+func (entry atomEntry) entry(base *url.URL) models.FeedEntry {
+	var link string
+	for _, l := range entry.Links {
+		if l.Rel == "" || l.Rel == "alternate" {
+			link = resolveLink(base, l.Href)
+			break
+		}
+	}
+	body := cmp.Or(entry.Content.Body, entry.Summary.Body)
+	return newFeedEntry(strings.TrimSpace(entry.ID), entry.Title.Text(), link, body)
+}
+
+// newFeedEntry identifies an entry by its GUID, link or title, and digests everything a reader sees of it.
+func newFeedEntry(id, title, link, body string) models.FeedEntry {
+	return models.FeedEntry{
+		ID:     cmp.Or(id, link, title),
+		Title:  title,
+		Link:   link,
+		Digest: models.DigestContent(strings.Join([]string{title, link, strings.TrimSpace(body)}, "\n")),
+	}
+}
+
+func resolveLink(base *url.URL, link string) string {
+	link = strings.TrimSpace(link)
+	if link == "" || base == nil {
+		return link
+	}
+	ref, err := url.Parse(link)
+	if err != nil {
+		return link
+	}
+	return base.ResolveReference(ref).String()
+}
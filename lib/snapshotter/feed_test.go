@@ -0,0 +1,142 @@
+package snapshotter
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/fiffu/diffwatch/lib/models"
+)
+
+const rssFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom" xmlns:content="http://purl.org/rss/1.0/modules/content/">
+<channel>
+	<title> Example
+		News </title>
+	<image><url>/logo.png</url></image>
+	<item>
+		<guid>tag:example.com,2024:1</guid>
+		<title>First &amp; foremost</title>
+		<atom:link href="https://example.com/self" rel="self"/>
+		<link>/posts/1</link>
+		<description>Summary &nbsp;one</description>
+	</item>
+	<item>
+		<title>Second</title>
+		<link>https://example.com/posts/2</link>
+		<content:encoded><![CDATA[<p>Full <b>text</b></p>]]></content:encoded>
+	</item>
+</channel>
+</rss>`
+
+const rdfFeed = `<?xml version="1.0"?>
+<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#" xmlns="http://purl.org/rss/1.0/">
+	<channel><title>RDF</title></channel>
+	<item rdf:about="https://example.com/a"><title>A</title><link>https://example.com/a</link></item>
+</rdf:RDF>`
+
+const atomFeedXML = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+	<title type="html">Atom &lt;b&gt;Feed&lt;/b&gt;</title>
+	<icon>/favicon.ico</icon>
+	<entry>
+		<id>urn:uuid:1</id>
+		<title type="xhtml"><div xmlns="http://www.w3.org/1999/xhtml">An <em>entry</em></div></title>
+		<link rel="edit" href="/edit/1"/>
+		<link href="/entries/1"/>
+		<summary>Short</summary>
+	</entry>
+</feed>`
+
+func TestParseFeed(t *testing.T) {
+	base, _ := url.Parse("https://example.com/feed.xml")
+	type entry struct{ ID, Title, Link string }
+
+	tests := []struct {
+		name      string
+		body      string
+		wantTitle string
+		wantImage string
+		want      []entry
+	}{
+		{"rss", rssFeed, "Example News", "https://example.com/logo.png", []entry{
+			{"tag:example.com,2024:1", "First & foremost", "https://example.com/posts/1"},
+			{"https://example.com/posts/2", "Second", "https://example.com/posts/2"},
+		}},
+		{"rss 1.0", rdfFeed, "RDF", "", []entry{
+			{"https://example.com/a", "A", "https://example.com/a"},
+		}},
+		{"atom", atomFeedXML, "Atom Feed", "https://example.com/favicon.ico", []entry{
+			{"urn:uuid:1", "An entry", "https://example.com/entries/1"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			feed, err := ParseFeed([]byte(tt.body), "application/xml", "", base)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if feed.Title != tt.wantTitle || feed.ImageURL != tt.wantImage {
+				t.Errorf("title, image = %q, %q; want %q, %q", feed.Title, feed.ImageURL, tt.wantTitle, tt.wantImage)
+			}
+			got := make([]entry, len(feed.Entries))
+			for i, e := range feed.Entries {
+				got[i] = entry{e.ID, e.Title, e.Link}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("entries = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFeedDigestsWhatReadersSee(t *testing.T) {
+	parse := func(body string) models.FeedEntry {
+		feed, err := ParseFeed([]byte(body), "application/rss+xml", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return feed.Entries[0]
+	}
+	item := func(title, description string) string {
+		return `<rss><channel><item><guid>1</guid><title>` + title + `</title><description>` + description + `</description></item></channel></rss>`
+	}
+
+	original := parse(item("A", "Body"))
+	if parse(item("A", "Body")).Digest != original.Digest {
+		t.Error("the same entry digests differently")
+	}
+	if parse(item("A", "Edited body")).Digest == original.Digest {
+		t.Error("editing the body didn't change the digest")
+	}
+	if parse(item("B", "Body")).Digest == original.Digest {
+		t.Error("editing the title didn't change the digest")
+	}
+}
+
+func TestParseFeedCharsets(t *testing.T) {
+	latin1 := "<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?><rss><channel><title>Caf\xe9</title></channel></rss>"
+	for _, tt := range []struct{ name, contentType, override string }{
+		{"declared in the xml", "application/rss+xml", ""},
+		{"declared in the header", "application/rss+xml; charset=iso-8859-1", ""},
+		{"overridden", "application/rss+xml; charset=utf-8", "latin1"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			feed, err := ParseFeed([]byte(latin1), tt.contentType, tt.override, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if feed.Title != "Café" {
+				t.Errorf("title = %q, want Café", feed.Title)
+			}
+		})
+	}
+}
+
+func TestParseFeedRejectsOtherDocuments(t *testing.T) {
+	for _, body := range []string{"", "<html><body>Hi</body></html>", "not xml at all"} {
+		if _, err := ParseFeed([]byte(body), "text/html", "", nil); err == nil {
+			t.Errorf("ParseFeed(%q) succeeded, want an error", body)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package snapshotter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/carlmjohnson/requests"
+	"github.com/fiffu/diffwatch/lib/models"
+	"github.com/fiffu/diffwatch/lib/proxies"
+)
+
+// RejectedResponseError is returned for a successful response that we refuse to process, e.g. one that is too large.
+type RejectedResponseError struct {
+	Endpoint string
+	Reason   string
+}
+
+func (e *RejectedResponseError) Error() string {
+	return fmt.Sprintf("rejected response from %s: %s", e.Endpoint, e.Reason)
+}
+
+// fetchLimits bounds what we are willing to download from an endpoint.
+type fetchLimits struct {
+	maxBodyBytes        int64
+	allowedContentTypes []string
+}
+
+// fetchResult is the outcome of requesting a subscription's endpoint.
+type fetchResult struct {
+	status       int // HTTP status of the last response received, or 0 if there was none
+	body         []byte
+	header       http.Header
+	contentType  string
+	notModified  bool // The server answered 304 to our conditional request, so there is no body
+	etag         string
+	lastModified string
+}
+
+// retryPolicy controls how often a failed fetch is retried within the same poll.
+type retryPolicy struct {
+	maxRetries int
+	baseDelay  time.Duration // Doubled after every attempt, plus some jitter
+}
+
+func (p retryPolicy) delay(attempt int) time.Duration {
+	d := p.baseDelay << attempt
+	if jitter := p.baseDelay / 2; jitter > 0 {
+		d += rand.N(jitter)
+	}
+	return d
+}
+
+// fetch requests the target, retrying transient failures with backoff; the result is never nil, even on error.
+func (s *Snapshotter) fetch(ctx context.Context, target *models.Target, validators models.CacheValidators) (*fetchResult, error) {
+	var host string
+	if u, err := url.Parse(target.Endpoint); err == nil {
+		host = u.Hostname()
+	}
+
+	for attempt := 0; ; attempt++ {
+		result, err := s.fetchOnce(ctx, target, validators)
+		if err == nil || attempt >= s.retries.maxRetries || !isTransient(ctx, err) {
+			return result, err
+		}
+
+		delay := max(s.retries.delay(attempt), time.Until(s.hosts.backoffUntil(host)))
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			return result, err
+		}
+		s.log.Sugar().Infow("Retrying fetch", "target_id", target.ID, "attempt", attempt+1, "delay", delay, "err", err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return result, err
+		}
+	}
+}
+
+// isTransient reports whether a failed fetch is worth retrying: timeouts, dropped connections and 5xx responses.
+func isTransient(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if requests.HasStatusErr(err,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+	) {
+		return true
+	}
+	if netErr := net.Error(nil); errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// fetchOnce makes a single request for the target, paced per host and conditional on any validators given.
+func (s *Snapshotter) fetchOnce(ctx context.Context, target *models.Target, validators models.CacheValidators) (*fetchResult, error) {
+	result := &fetchResult{}
+
+	u, err := url.Parse(target.Endpoint)
+	if err != nil {
+		return result, err
+	}
+	proxyURL, err := s.proxyFor(&target.WatchSpec)
+	if err != nil {
+		return result, err
+	}
+	ctx = proxies.WithProxy(ctx, proxyURL)
+	if s.robots != nil {
+		if err := s.robots.check(ctx, target.Endpoint); err != nil {
+			return result, err
+		}
+	}
+	release, err := s.hosts.acquire(ctx, u.Hostname())
+	if err != nil {
+		return result, err
+	}
+	defer release()
+
+	err = s.requestBuilder(&target.WatchSpec).
+		HeaderOptional("If-None-Match", validators.ETag).
+		HeaderOptional("If-Modified-Since", validators.LastModified).
+		AddValidator(func(res *http.Response) error {
+			result.status = res.StatusCode
+			s.hosts.observe(u.Hostname(), res)
+			if res.StatusCode == http.StatusNotModified {
+				return nil
+			}
+			return requests.DefaultValidator(res)
+		}).
+		Handle(func(res *http.Response) (err error) {
+			result.header = res.Header
+			result.contentType = res.Header.Get("Content-Type")
+			result.etag = res.Header.Get("ETag")
+			result.lastModified = res.Header.Get("Last-Modified")
+			if res.StatusCode == http.StatusNotModified {
+				result.notModified = true
+				return nil
+			}
+			result.body, err = s.limits.read(target.Endpoint, res)
+			return
+		}).
+		Fetch(ctx)
+	return result, err
+}
+
+// proxyFor returns the proxy that the subscription's requests should go through, or nil to connect directly.
+func (s *Snapshotter) proxyFor(spec *models.WatchSpec) (*url.URL, error) {
+	switch {
+	case spec.ProxyURL != "":
+		return proxies.Parse(string(spec.ProxyURL))
+	case spec.ProxyProfile != "":
+		proxyURL, ok := s.proxyProfiles[spec.ProxyProfile]
+		if !ok {
+			return nil, fmt.Errorf("unknown proxy profile: %s", spec.ProxyProfile)
+		}
+		return proxyURL, nil
+	}
+	return nil, nil
+}
+
+func (s *Snapshotter) requestBuilder(spec *models.WatchSpec) *requests.Builder {
+	req := spec.Request
+	rb := requests.URL(spec.Endpoint).
+		Transport(s.transport).
+		HeaderOptional("User-Agent", s.userAgent)
+
+	if req.Method != "" {
+		rb.Method(req.Method)
+	}
+	if len(req.Headers) > 0 {
+		rb.Headers(req.Headers)
+	}
+	if req.Body != "" {
+		rb.BodyBytes([]byte(req.Body))
+		if http.Header(req.Headers).Get("Content-Type") == "" {
+			rb.ContentType("application/x-www-form-urlencoded")
+		}
+	}
+	switch req.AuthScheme() {
+	case "bearer":
+		rb.Bearer(string(req.BearerToken))
+	case "basic":
+		rb.BasicAuth(req.BasicUser, string(req.BasicPassword))
+	}
+	return rb
+}
+
+// read streams the response body, giving up as soon as it exceeds the size limit, and checks its content type.
+func (l fetchLimits) read(endpoint string, res *http.Response) ([]byte, error) {
+	reject := func(format string, args ...any) error {
+		return &RejectedResponseError{endpoint, fmt.Sprintf(format, args...)}
+	}
+
+	if l.maxBodyBytes > 0 && res.ContentLength > l.maxBodyBytes {
+		return nil, reject("content length %d exceeds limit of %d bytes", res.ContentLength, l.maxBodyBytes)
+	}
+
+	reader := io.Reader(res.Body)
+	if l.maxBodyBytes > 0 {
+		reader = io.LimitReader(res.Body, l.maxBodyBytes+1)
+	}
+
+	// Check the content type before downloading the rest of the body, sniffing it if the header is missing.
+	head := make([]byte, 512)
+	n, err := io.ReadFull(reader, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	head = head[:n]
+
+	contentType := res.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(head)
+	}
+	if !l.allowsContentType(contentType) {
+		return nil, reject("content type %q is not allowed", contentType)
+	}
+
+	buf := bytes.NewBuffer(head)
+	if _, err := buf.ReadFrom(reader); err != nil {
+		return nil, err
+	}
+	if l.maxBodyBytes > 0 && int64(buf.Len()) > l.maxBodyBytes {
+		return nil, reject("body exceeds limit of %d bytes", l.maxBodyBytes)
+	}
+	return buf.Bytes(), nil
+}
+
+// allowsContentType matches the media type against the allowlist, where entries like "text/*" match any subtype.
+func (l fetchLimits) allowsContentType(contentType string) bool {
+	if len(l.allowedContentTypes) == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range l.allowedContentTypes {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if prefix, ok := strings.CutSuffix(allowed, "/*"); ok {
+			if strings.HasPrefix(mediaType, prefix+"/") {
+				return true
+			}
+		} else if mediaType == allowed {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package snapshotter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fiffu/diffwatch/lib/models"
+	"go.uber.org/zap"
+)
+
+func TestFetchRetriesAfterHostBackoff(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	s := &Snapshotter{
+		log:       zap.NewNop(),
+		transport: http.DefaultTransport,
+		hosts:     newHostScheduler(politenessConfig{defaultBackoff: time.Minute}),
+		retries:   retryPolicy{maxRetries: 2, baseDelay: 10 * time.Millisecond},
+		limits:    fetchLimits{maxBodyBytes: 1024},
+	}
+	target := &models.Target{WatchSpec: models.WatchSpec{Endpoint: srv.URL}}
+
+	t.Run("waits out the backoff", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		res, err := s.fetch(ctx, target, models.CacheValidators{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(res.body) != "ok" || requests.Load() != 2 {
+			t.Errorf("got body %q after %d requests, want \"ok\" after 2", res.body, requests.Load())
+		}
+	})
+
+	t.Run("gives up if the backoff outlasts the deadline", func(t *testing.T) {
+		requests.Store(0)
+		s.hosts = newHostScheduler(politenessConfig{})
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+		res, err := s.fetch(ctx, target, models.CacheValidators{})
+		if err == nil || res.status != http.StatusServiceUnavailable || requests.Load() != 1 {
+			t.Errorf("got status %d, err %v after %d requests, want a 503 after 1", res.status, err, requests.Load())
+		}
+	})
+}
@@ -0,0 +1,66 @@
+package snapshotter
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/fiffu/diffwatch/lib/models"
+)
+
+// flipPage changes the page through the given texts, polling the subscription after each.
+func flipPage(t *testing.T, s *Snapshotter, p *page, texts ...string) {
+	for _, text := range texts {
+		p.set(text, http.StatusOK)
+		runAgain(t, s)
+	}
+}
+
+func TestRunDueJobsSuppressesFlaps(t *testing.T) {
+	s, sender := newTestSnapshotter(t)
+	s.flapWindow = time.Hour
+	p, srv := newPage(t, "two")
+	sub := subscribe(t, s, srv.URL, "one")
+	runAgain(t, s)
+
+	flipPage(t, s, p, "three", "two")
+	if got := sender.sentTo(sub); len(got) != 2 || got[1] != "three" {
+		t.Errorf("subscription was sent %q, want [two three] without the flap back to two", got)
+	}
+	var snaps []models.Snapshot
+	if err := s.db.Where("subscription_id = ?", sub.ID).Order("timestamp").Find(&snaps).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(snaps) != 4 || !snaps[3].Reverted || snaps[2].Reverted {
+		t.Errorf("snapshots = %+v, want only the last of 4 marked reverted", snaps)
+	}
+
+	flipPage(t, s, p, "four")
+	if got := sender.sentTo(sub); len(got) != 3 || got[2] != "four" {
+		t.Errorf("subscription was sent %q, want [two three four]", got)
+	}
+}
+
+func TestRunDueJobsNotifiesFlapsOutsideWindow(t *testing.T) {
+	s, sender := newTestSnapshotter(t)
+	p, srv := newPage(t, "two")
+	sub := subscribe(t, s, srv.URL, "one")
+	runAgain(t, s)
+
+	flipPage(t, s, p, "three", "two")
+	if got := sender.sentTo(sub); len(got) != 3 || got[2] != "two" {
+		t.Errorf("subscription was sent %q, want [two three two] without a flap window", got)
+	}
+
+	// Flapping back to content seen before the window is a change of its own
+	s.flapWindow = time.Hour
+	tx := s.db.Model(&models.Subscription{}).Where("id = ?", sub.ID).
+		Update("recent_digests", models.RecentDigests{}.Push((&models.EndpointContent{Text: "three"}).Digest(), time.Now().UTC().Add(-2*time.Hour)))
+	if err := tx.Error; err != nil {
+		t.Fatal(err)
+	}
+	flipPage(t, s, p, "three")
+	if got := sender.sentTo(sub); len(got) != 4 || got[3] != "three" {
+		t.Errorf("subscription was sent %q, want [two three two three]", got)
+	}
+}
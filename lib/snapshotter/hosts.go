@@ -0,0 +1,172 @@
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HostBackoffError is returned without making a request while a host has asked us to back off.
+type HostBackoffError struct {
+	Host  string
+	Until time.Time
+}
+
+func (e *HostBackoffError) Error() string {
+	return fmt.Sprintf("backing off from %s until %s", e.Host, e.Until.Format(time.RFC3339))
+}
+
+// politenessConfig controls how hard we are willing to hit any single host.
+type politenessConfig struct {
+	maxConcurrent  int           // Requests in flight to one host at a time
+	minInterval    time.Duration // Minimum gap between the starts of consecutive requests to one host
+	defaultBackoff time.Duration // How long to back off after a 429 or 503 that carries no Retry-After
+}
+
+// hostIdleTTL is how long a host goes unused before its state is dropped.
+const hostIdleTTL = 10 * time.Minute
+
+// hostScheduler spaces out requests per host, regardless of which subscriptions they belong to.
+type hostScheduler struct {
+	cfg politenessConfig
+
+	mu        sync.Mutex
+	hosts     map[string]*hostState
+	lastSweep time.Time
+}
+
+type hostState struct {
+	slots    chan struct{} // Semaphore limiting concurrent requests
+	lastUsed time.Time     // Guarded by hostScheduler.mu
+
+	mu           sync.Mutex
+	nextStart    time.Time // Earliest time that the next request may start
+	backoffUntil time.Time // Set when the host answers 429 or 503
+}
+
+func newHostScheduler(cfg politenessConfig) *hostScheduler {
+	if cfg.maxConcurrent < 1 {
+		cfg.maxConcurrent = 1
+	}
+	return &hostScheduler{cfg: cfg, hosts: make(map[string]*hostState), lastSweep: time.Now()}
+}
+
+func (h *hostScheduler) state(host string) *hostState {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(h.lastSweep) >= hostIdleTTL {
+		h.sweep(now)
+	}
+
+	host = strings.ToLower(host)
+	st, ok := h.hosts[host]
+	if !ok {
+		st = &hostState{slots: make(chan struct{}, h.cfg.maxConcurrent)}
+		h.hosts[host] = st
+	}
+	st.lastUsed = now
+	return st
+}
+
+// sweep drops hosts that have gone unused for hostIdleTTL, and have no requests in flight or pacing to remember.
+func (h *hostScheduler) sweep(now time.Time) {
+	h.lastSweep = now
+	for host, st := range h.hosts {
+		if now.Sub(st.lastUsed) >= hostIdleTTL && st.idle(now) {
+			delete(h.hosts, host)
+		}
+	}
+}
+
+// acquire waits until a request to host may start, and returns a func to call once the request is done.
+func (h *hostScheduler) acquire(ctx context.Context, host string) (release func(), err error) {
+	st := h.state(host)
+
+	if until := st.backoff(); time.Now().Before(until) {
+		return nil, &HostBackoffError{host, until}
+	}
+
+	select {
+	case st.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	release = func() { <-st.slots }
+
+	st.mu.Lock()
+	now := time.Now()
+	start := now
+	if st.nextStart.After(now) {
+		start = st.nextStart
+	}
+	st.nextStart = start.Add(h.cfg.minInterval)
+	st.mu.Unlock()
+
+	if wait := time.Until(start); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		}
+	}
+	return release, nil
+}
+
+// observe inspects a response from host, backing off if the host says it is overloaded or rate limiting us.
+func (h *hostScheduler) observe(host string, res *http.Response) {
+	if res.StatusCode != http.StatusTooManyRequests && res.StatusCode != http.StatusServiceUnavailable {
+		return
+	}
+	delay, ok := parseRetryAfter(res.Header.Get("Retry-After"), time.Now())
+	if !ok {
+		delay = h.cfg.defaultBackoff
+	}
+
+	st := h.state(host)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if until := time.Now().Add(delay); until.After(st.backoffUntil) {
+		st.backoffUntil = until
+	}
+}
+
+// backoffUntil returns when the host's backoff ends, which is in the past if there is none.
+func (h *hostScheduler) backoffUntil(host string) time.Time {
+	return h.state(host).backoff()
+}
+
+func (st *hostState) idle(now time.Time) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return len(st.slots) == 0 && !now.Before(st.nextStart) && !now.Before(st.backoffUntil)
+}
+
+func (st *hostState) backoff() time.Time {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.backoffUntil
+}
+
+// parseRetryAfter reads a Retry-After header, which holds either a number of seconds or an HTTP date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(secs, 0)) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(t.Sub(now), 0), true
+	}
+	return 0, false
+}
@@ -0,0 +1,109 @@
+package snapshotter
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHostSchedulerPacesRequests(t *testing.T) {
+	h := newHostScheduler(politenessConfig{maxConcurrent: 2, minInterval: 50 * time.Millisecond})
+	ctx := context.Background()
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		release, err := h.acquire(ctx, "example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		release()
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("3 requests started within %s, want them at least 50ms apart", elapsed)
+	}
+
+	// Other hosts aren't held up
+	start = time.Now()
+	release, err := h.acquire(ctx, "example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if elapsed := time.Since(start); elapsed > 25*time.Millisecond {
+		t.Errorf("request to another host waited %s", elapsed)
+	}
+}
+
+func TestHostSchedulerBacksOff(t *testing.T) {
+	h := newHostScheduler(politenessConfig{defaultBackoff: time.Hour})
+	h.observe("example.com", &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}})
+
+	_, err := h.acquire(context.Background(), "EXAMPLE.com")
+	if _, ok := err.(*HostBackoffError); !ok {
+		t.Errorf("acquire() = %v, want a HostBackoffError", err)
+	}
+	if until := h.backoffUntil("example.com"); time.Until(until) < 59*time.Minute {
+		t.Errorf("backing off until %s, want the default hour", until)
+	}
+}
+
+func TestHostSchedulerDropsIdleHosts(t *testing.T) {
+	h := newHostScheduler(politenessConfig{maxConcurrent: 1, minInterval: time.Millisecond})
+	ctx := context.Background()
+
+	release, err := h.acquire(ctx, "idle.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if _, err := h.acquire(ctx, "busy.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	h.observe("slow.example.com", &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{"Retry-After": {"3600"}}})
+
+	// Everything was last used long ago
+	time.Sleep(5 * time.Millisecond)
+	h.mu.Lock()
+	h.lastSweep = h.lastSweep.Add(-hostIdleTTL)
+	for _, st := range h.hosts {
+		st.lastUsed = st.lastUsed.Add(-hostIdleTTL)
+	}
+	h.mu.Unlock()
+	h.state("new.example.com")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for host, want := range map[string]bool{
+		"idle.example.com": false,
+		"busy.example.com": true, // Has a request in flight
+		"slow.example.com": true, // Is backing off
+		"new.example.com":  true,
+	} {
+		if _, got := h.hosts[host]; got != want {
+			t.Errorf("%s kept = %v, want %v", host, got, want)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"120", 2 * time.Minute, true},
+		{"-5", 0, true},
+		{"Sat, 01 Jun 2024 12:05:00 GMT", 5 * time.Minute, true},
+		{"Sat, 01 Jun 2024 11:00:00 GMT", 0, true},
+		{"", 0, false},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.value, now)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("parseRetryAfter(%q) = %s, %v; want %s, %v", tt.value, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
@@ -2,10 +2,12 @@ package snapshotter
 
 import (
 	"bytes"
+	"net/url"
 	"regexp"
 	"strings"
 
 	"github.com/antchfx/htmlquery"
+	"github.com/fiffu/diffwatch/lib/models"
 	"golang.org/x/net/html"
 )
 
@@ -52,6 +54,120 @@ func SelectText(n *html.Node, xpath string) string {
 	return digForText(node)
 }
 
+// Extractor takes values out of the nodes matched by a subscription's selector, according to its extraction mode.
+type Extractor struct {
+	Mode models.ExtractMode
+	Attr string   // Attribute to take in attribute mode, unless the node is an attribute selected by XPath
+	Base *url.URL // Relative URLs are resolved against this
+}
+
+// NewExtractor returns an extractor for the spec, resolving URLs the way a browser would for doc.
+func NewExtractor(spec *models.WatchSpec, doc *html.Node) Extractor {
+	mode, attr := spec.Extraction()
+	return Extractor{mode, attr, documentBase(doc, spec.Endpoint)}
+}
+
+func (e Extractor) ExtractAll(nodes []*html.Node) []string {
+	values := make([]string, len(nodes))
+	for i, node := range nodes {
+		values[i] = e.Extract(node)
+	}
+	return values
+}
+
+func (e Extractor) Extract(n *html.Node) string {
+	if n == nil {
+		return ""
+	}
+	switch e.Mode {
+	case models.ExtractAttribute:
+		return e.attribute(n)
+	case models.ExtractOuterHTML:
+		e.resolveURLs(n)
+		return renderHTML(n)
+	case models.ExtractInnerHTML:
+		e.resolveURLs(n)
+		var b strings.Builder
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			b.WriteString(renderHTML(c))
+		}
+		return strings.TrimSpace(b.String())
+	default:
+		return digForText(n)
+	}
+}
+
+func (e Extractor) attribute(n *html.Node) string {
+	// htmlquery returns an attribute as a detached element named after it, holding its value
+	if n.Type == html.ElementNode && n.Parent == nil && n.FirstChild != nil && n.FirstChild == n.LastChild {
+		if e.Attr == "" || e.Attr == n.Data {
+			return strings.TrimSpace(e.resolveAttr(n.Data, n.FirstChild.Data))
+		}
+	}
+	for _, attr := range n.Attr {
+		if attr.Key == e.Attr {
+			return strings.TrimSpace(e.resolveAttr(attr.Key, attr.Val))
+		}
+	}
+	return ""
+}
+
+// urlAttributes are the attributes whose values are URLs.
+var urlAttributes = map[string]bool{
+	"action":     true,
+	"background": true,
+	"cite":       true,
+	"data":       true,
+	"formaction": true,
+	"href":       true,
+	"longdesc":   true,
+	"poster":     true,
+	"src":        true,
+}
+
+func (e Extractor) resolveAttr(key, val string) string {
+	if !urlAttributes[key] || e.Base == nil || strings.TrimSpace(val) == "" {
+		return val
+	}
+	ref, err := url.Parse(strings.TrimSpace(val))
+	if err != nil {
+		return val
+	}
+	return e.Base.ResolveReference(ref).String()
+}
+
+// resolveURLs makes the URLs in the attributes of n and its descendants absolute.
+func (e Extractor) resolveURLs(n *html.Node) {
+	for i, attr := range n.Attr {
+		n.Attr[i].Val = e.resolveAttr(attr.Key, attr.Val)
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		e.resolveURLs(c)
+	}
+}
+
+func renderHTML(n *html.Node) string {
+	var b strings.Builder
+	if err := html.Render(&b, n); err != nil {
+		return ""
+	}
+	return b.String()
+}
+
+// documentBase returns the document's <base href> resolved against the endpoint, or the endpoint itself.
+func documentBase(doc *html.Node, endpoint string) *url.URL {
+	base, err := url.Parse(endpoint)
+	if err != nil {
+		return nil
+	}
+	if elem := htmlquery.FindOne(doc, "//base[@href]"); elem != nil {
+		if ref, err := url.Parse(htmlquery.SelectAttr(elem, "href")); err == nil {
+			base = base.ResolveReference(ref)
+		}
+	}
+	return base
+}
+
 func digForText(n *html.Node) string {
 	if n == nil {
 		return ""
@@ -0,0 +1,88 @@
+package snapshotter
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/antchfx/htmlquery"
+	"github.com/fiffu/diffwatch/lib/models"
+)
+
+const extractDoc = `<html><head><base href="/shop/"></head><body>
+<div id="item">
+	<a href="item/1?ref=list">Widget   <b>One</b></a>
+	<img src="//cdn.example.com/1.png" alt=" A widget ">
+</div>
+</body></html>`
+
+func TestExtractorExtract(t *testing.T) {
+	doc, err := htmlquery.Parse(strings.NewReader(extractDoc))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		mode  models.ExtractMode
+		attr  string
+		xpath string
+		want  string
+	}{
+		{"text", "", "", "//a", "Widget One"},
+		{"URL attribute", models.ExtractAttribute, "href", "//a", "https://example.com/shop/item/1?ref=list"},
+		{"protocol-relative URL", models.ExtractAttribute, "src", "//img", "https://cdn.example.com/1.png"},
+		{"other attribute", models.ExtractAttribute, "alt", "//img", "A widget"},
+		{"missing attribute", models.ExtractAttribute, "title", "//img", ""},
+		{"attribute selected by XPath", models.ExtractAttribute, "", "//a/@href", "https://example.com/shop/item/1?ref=list"},
+		{"other attribute than selected", models.ExtractAttribute, "alt", "//a/@href", ""},
+		{"outer HTML", models.ExtractOuterHTML, "", "//a", `<a href="https://example.com/shop/item/1?ref=list">Widget   <b>One</b></a>`},
+		{"inner HTML", models.ExtractInnerHTML, "", "//div", `<a href="https://example.com/shop/item/1?ref=list">Widget   <b>One</b></a>
+	<img src="https://cdn.example.com/1.png" alt=" A widget "/>`},
+		{"nothing matched", models.ExtractOuterHTML, "", "//table", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := &models.WatchSpec{Endpoint: "https://example.com/index.html", ExtractMode: tt.mode, ExtractAttr: tt.attr}
+			// Extracting markup resolves URLs in place, so each case gets a fresh document
+			doc, _ := htmlquery.Parse(strings.NewReader(extractDoc))
+			if got := NewExtractor(spec, doc).Extract(htmlquery.FindOne(doc, tt.xpath)); got != tt.want {
+				t.Errorf("Extract() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("all matches", func(t *testing.T) {
+		spec := &models.WatchSpec{Endpoint: "https://example.com/", ExtractMode: models.ExtractAttribute, ExtractAttr: "href"}
+		got := NewExtractor(spec, doc).ExtractAll(htmlquery.Find(doc, "//a | //img"))
+		if len(got) != 2 || got[0] != "https://example.com/shop/item/1?ref=list" || got[1] != "" {
+			t.Errorf("ExtractAll() = %q", got)
+		}
+	})
+}
+
+func TestDocumentBase(t *testing.T) {
+	tests := []struct {
+		name, doc, endpoint, want string
+	}{
+		{"endpoint", "<html></html>", "https://example.com/a/b", "https://example.com/a/b"},
+		{"relative base", `<base href="../c/">`, "https://example.com/a/b", "https://example.com/c/"},
+		{"absolute base", `<base href="https://cdn.example.com/">`, "https://example.com/a/b", "https://cdn.example.com/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := htmlquery.Parse(strings.NewReader(tt.doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := documentBase(doc, tt.endpoint); got.String() != tt.want {
+				t.Errorf("documentBase() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	// Without a parsable endpoint, URLs are left as they are
+	e := Extractor{Mode: models.ExtractAttribute, Attr: "href", Base: nil}
+	if got := e.resolveAttr("href", "item/1"); got != "item/1" {
+		t.Errorf("resolveAttr() = %q, want it unresolved", got)
+	}
+}
@@ -0,0 +1,115 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/fiffu/diffwatch/config"
+	"github.com/fiffu/diffwatch/lib/models"
+	"gorm.io/gorm/clause"
+)
+
+// Keys of the intervals in the settings table
+const (
+	pollIntervalSetting  = "poll_interval"
+	chaseIntervalSetting = "chase_interval"
+)
+
+// Intervals are the snapshotter's intervals that can be changed while it runs.
+type Intervals struct {
+	Poll  time.Duration // Subscriptions without a schedule of their own are polled this often
+	Chase time.Duration // When a subscription is updated, we'll poll it again after this duration
+}
+
+// runtimeIntervals caches the intervals saved in the settings table, which polls read while they're reloaded.
+type runtimeIntervals struct {
+	mu       sync.RWMutex
+	defaults Intervals // Configured intervals, used until others are saved
+	Intervals
+}
+
+// Intervals loads the intervals in effect for every process, and caches them for polls.
+func (s *Snapshotter) Intervals(ctx context.Context) (Intervals, error) {
+	var settings []models.Setting
+	tx := s.db.WithContext(ctx).Where("key IN ?", []string{pollIntervalSetting, chaseIntervalSetting}).Find(&settings)
+	if err := tx.Error; err != nil {
+		return s.cachedIntervals(), err
+	}
+
+	intervals := s.intervals.defaults
+	for _, setting := range settings {
+		d, err := time.ParseDuration(setting.Value)
+		if err != nil {
+			s.log.Sugar().Errorw("Ignoring invalid interval setting", "key", setting.Key, "value", setting.Value, "err", err)
+			continue
+		}
+		switch setting.Key {
+		case pollIntervalSetting:
+			intervals.Poll = d
+		case chaseIntervalSetting:
+			intervals.Chase = d
+		}
+	}
+
+	s.intervals.mu.Lock()
+	defer s.intervals.mu.Unlock()
+	s.intervals.Intervals = intervals
+	return intervals, nil
+}
+
+// ValidateIntervals checks the intervals to be set against their minimums, ignoring zero ones.
+func ValidateIntervals(update Intervals) error {
+	var errs []error
+	if update.Poll != 0 {
+		errs = append(errs, config.AtLeast("poll interval", update.Poll, config.MinPollInterval))
+	}
+	if update.Chase != 0 {
+		errs = append(errs, config.AtLeast("chase interval", update.Chase, config.MinChaseInterval))
+	}
+	return errors.Join(errs...)
+}
+
+// SetIntervals saves the intervals for every process, leaving those given as zero unchanged.
+func (s *Snapshotter) SetIntervals(ctx context.Context, update Intervals) (Intervals, error) {
+	if err := ValidateIntervals(update); err != nil {
+		return s.cachedIntervals(), err
+	}
+
+	settings := make([]models.Setting, 0)
+	if update.Poll != 0 {
+		settings = append(settings, models.Setting{Key: pollIntervalSetting, Value: update.Poll.String()})
+	}
+	if update.Chase != 0 {
+		settings = append(settings, models.Setting{Key: chaseIntervalSetting, Value: update.Chase.String()})
+	}
+	if len(settings) > 0 {
+		tx := s.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(&settings)
+		if err := tx.Error; err != nil {
+			return s.cachedIntervals(), err
+		}
+	}
+
+	intervals, err := s.Intervals(ctx)
+	if err != nil {
+		return intervals, err
+	}
+	s.log.Sugar().Infow("Snapshotter intervals changed", "poll_interval", intervals.Poll, "chase_interval", intervals.Chase)
+	return intervals, nil
+}
+
+// cachedIntervals returns the intervals last loaded.
+func (s *Snapshotter) cachedIntervals() Intervals {
+	s.intervals.mu.RLock()
+	defer s.intervals.mu.RUnlock()
+	return s.intervals.Intervals
+}
+
+func (s *Snapshotter) pollInterval() time.Duration {
+	return s.cachedIntervals().Poll
+}
+
+func (s *Snapshotter) chaseInterval() time.Duration {
+	return s.cachedIntervals().Chase
+}
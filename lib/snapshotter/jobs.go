@@ -0,0 +1,162 @@
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"time"
+
+	"github.com/fiffu/diffwatch/lib/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// newWorkerID returns a name for this process's leases on jobs, unique among the processes sharing the database.
+func newWorkerID() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d-%08x", host, os.Getpid(), rand.Uint32())
+}
+
+// enqueuePolls adds a poll job for every target with a due subscription, unless it has one already.
+func (s *Snapshotter) enqueuePolls(ctx context.Context, now time.Time) error {
+	if err := s.linkTargets(ctx); err != nil {
+		return err
+	}
+
+	var targetIDs []uint
+	tx := s.db.Model(&models.Subscription{}).
+		Scopes(s.polled(now), due(now)).
+		Where("NOT EXISTS (SELECT 1 FROM jobs WHERE jobs.target_id = subscriptions.target_id AND jobs.kind = ?)", models.JobPoll).
+		Distinct().
+		Pluck("target_id", &targetIDs)
+	if err := tx.Error; err != nil || len(targetIDs) == 0 {
+		return err
+	}
+
+	jobs := make(models.Jobs, len(targetIDs))
+	for i, targetID := range targetIDs {
+		jobs[i] = &models.Job{Kind: models.JobPoll, TargetID: targetID, RunAt: now}
+	}
+	tx = s.db.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(jobs, 100)
+	return tx.Error
+}
+
+// migrateChasers moves the chasers of versions before the job queue into chase jobs, and drops their table.
+func (s *Snapshotter) migrateChasers(ctx context.Context) error {
+	if !s.db.Migrator().HasTable("chasers") {
+		return nil
+	}
+	// Chasers are of subscriptions, which may not have been linked to targets yet
+	if err := s.linkTargets(ctx); err != nil {
+		return err
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(`
+			INSERT INTO jobs (created_at, kind, target_id, run_at, lease_owner, attempts)
+			SELECT ?, ?, subscriptions.target_id, MIN(chasers.not_before), '', 0
+			FROM chasers JOIN subscriptions ON subscriptions.id = chasers.subscription_id
+			WHERE subscriptions.deleted_at IS NULL AND subscriptions.target_id > 0
+			GROUP BY subscriptions.target_id
+			ON CONFLICT (kind, target_id) DO NOTHING`,
+			time.Now().UTC(), models.JobChase,
+		).Error
+		if err != nil {
+			return err
+		}
+		return tx.Migrator().DropTable("chasers")
+	})
+}
+
+// scheduleChase adds or moves the target's chase job, dropping its lease so that a running chase doesn't remove it.
+func (s *Snapshotter) scheduleChase(ctx context.Context, targetID uint, runAt time.Time) error {
+	job := models.Job{Kind: models.JobChase, TargetID: targetID, RunAt: runAt}
+	tx := s.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "kind"}, {Name: "target_id"}},
+		DoUpdates: clause.Assignments(map[string]any{
+			"run_at":           runAt,
+			"lease_owner":      "",
+			"lease_expires_at": nil,
+		}),
+	}).Create(&job)
+	return tx.Error
+}
+
+// claimJobs leases up to limit due or abandoned jobs to this worker in one statement, so no job is claimed twice.
+func (s *Snapshotter) claimJobs(ctx context.Context, now time.Time, limit int) (models.Jobs, error) {
+	due := s.db.Model(&models.Job{}).
+		Select("id").
+		Where("run_at <= ?", now).
+		Where("lease_expires_at IS NULL OR lease_expires_at < ?", now).
+		Order("run_at").
+		Limit(limit)
+
+	var jobs models.Jobs
+	tx := s.db.Model(&jobs).
+		Clauses(clause.Returning{}).
+		Where("id IN (?)", due).
+		Updates(map[string]any{
+			"lease_owner":      s.workerID,
+			"lease_expires_at": now.Add(s.leaseTTL),
+			"attempts":         gorm.Expr("attempts + 1"),
+		})
+	return jobs, tx.Error
+}
+
+// renewLeases keeps extending this worker's leases on the jobs it holds until the returned function is called.
+func (s *Snapshotter) renewLeases() (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(s.leaseTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case t := <-ticker.C:
+				tx := s.db.Model(&models.Job{}).
+					Where("lease_owner = ?", s.workerID).
+					Update("lease_expires_at", t.UTC().Add(s.leaseTTL))
+				if err := tx.Error; err != nil {
+					s.log.Sugar().Errorw("Failed to renew job leases", "err", err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// releaseJobs gives up this worker's leases on jobs it claimed but didn't start.
+func (s *Snapshotter) releaseJobs(jobs models.Jobs) {
+	ids := make([]uint, len(jobs))
+	for i, job := range jobs {
+		ids[i] = job.ID
+	}
+	tx := s.db.Model(&models.Job{}).
+		Where("id IN ?", ids).
+		Where("lease_owner = ?", s.workerID).
+		Updates(map[string]any{
+			"lease_owner":      "",
+			"lease_expires_at": nil,
+			"attempts":         gorm.Expr("attempts - 1"),
+		})
+	if err := tx.Error; err != nil {
+		s.log.Sugar().Errorw("Failed to release jobs", "job_ids", ids, "err", err)
+	}
+}
+
+// completeJob removes a job that this worker is done with, unless another worker has taken its lease.
+func (s *Snapshotter) completeJob(job *models.Job) {
+	tx := s.db.
+		Where("id = ?", job.ID).
+		Where("lease_owner = ?", s.workerID).
+		Delete(&models.Job{})
+	if err := tx.Error; err != nil {
+		s.log.Sugar().Errorw("Failed to complete job", "job_id", job.ID, "err", err)
+	}
+}
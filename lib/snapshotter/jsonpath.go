@@ -0,0 +1,553 @@
+package snapshotter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// JSONPath is a compiled JSONPath expression, e.g. "$.items[?(@.price < 10)].name". It covers the common subset of
+// Goessner's syntax, rather than pulling in a library whose filter dialect subscriptions would then depend on.
+type JSONPath struct {
+	expr  string
+	steps []jsonStep
+}
+
+type jsonStep struct {
+	recursive bool
+	selector  jsonSelector
+}
+
+type jsonSelector interface {
+	selectFrom(node any) []any
+}
+
+// CompileJSONPath parses a JSONPath expression.
+func CompileJSONPath(expr string) (*JSONPath, error) {
+	expr = strings.TrimSpace(expr)
+	if !strings.HasPrefix(expr, "$") {
+		return nil, fmt.Errorf("jsonpath must start with $: %s", expr)
+	}
+	steps, err := parseJSONSteps(expr[1:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid jsonpath %s: %w", expr, err)
+	}
+	return &JSONPath{expr, steps}, nil
+}
+
+func (p *JSONPath) String() string {
+	return p.expr
+}
+
+// Select evaluates the path against a decoded JSON document, returning the matched values in document order.
+func (p *JSONPath) Select(doc any) []any {
+	return evalJSONSteps(p.steps, doc)
+}
+
+func evalJSONSteps(steps []jsonStep, doc any) []any {
+	nodes := []any{doc}
+	for _, step := range steps {
+		next := make([]any, 0)
+		for _, node := range nodes {
+			if step.recursive {
+				for _, desc := range descendants(node) {
+					next = append(next, step.selector.selectFrom(desc)...)
+				}
+			} else {
+				next = append(next, step.selector.selectFrom(node)...)
+			}
+		}
+		nodes = next
+	}
+	return nodes
+}
+
+// descendants lists node and everything nested under it, in document order.
+func descendants(node any) []any {
+	out := []any{node}
+	for _, child := range children(node) {
+		out = append(out, descendants(child)...)
+	}
+	return out
+}
+
+func children(node any) []any {
+	switch v := node.(type) {
+	case []any:
+		return v
+	case map[string]any:
+		out := make([]any, 0, len(v))
+		for _, key := range sortedKeys(v) {
+			out = append(out, v[key])
+		}
+		return out
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type wildcardSelector struct{}
+
+func (wildcardSelector) selectFrom(node any) []any {
+	return children(node)
+}
+
+type nameSelector struct{ names []string }
+
+func (sel nameSelector) selectFrom(node any) []any {
+	obj, ok := node.(map[string]any)
+	if !ok {
+		return nil
+	}
+	out := make([]any, 0, len(sel.names))
+	for _, name := range sel.names {
+		if v, ok := obj[name]; ok {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+type indexSelector struct{ indices []int }
+
+func (sel indexSelector) selectFrom(node any) []any {
+	arr, ok := node.([]any)
+	if !ok {
+		return nil
+	}
+	out := make([]any, 0, len(sel.indices))
+	for _, i := range sel.indices {
+		if i < 0 {
+			i += len(arr)
+		}
+		if i >= 0 && i < len(arr) {
+			out = append(out, arr[i])
+		}
+	}
+	return out
+}
+
+type sliceSelector struct {
+	start, end *int
+	step       int
+}
+
+// selectFrom follows Python's slices, as RFC 9535 does: bounds count from the end if negative, and are then clamped
+// to the array, or to one before it when stepping backwards.
+func (sel sliceSelector) selectFrom(node any) []any {
+	arr, ok := node.([]any)
+	if !ok || sel.step == 0 {
+		return nil
+	}
+	n := len(arr)
+	bound := func(i *int, lowest, highest, unset int) int {
+		if i == nil {
+			return unset
+		}
+		b := *i
+		if b < 0 {
+			b += n
+		}
+		return max(lowest, min(b, highest))
+	}
+
+	out := make([]any, 0)
+	if sel.step > 0 {
+		start, end := bound(sel.start, 0, n, 0), bound(sel.end, 0, n, n)
+		for i := start; i < end; i += sel.step {
+			out = append(out, arr[i])
+		}
+	} else {
+		start, end := bound(sel.start, -1, n-1, n-1), bound(sel.end, -1, n-1, -1)
+		for i := start; i > end; i += sel.step {
+			out = append(out, arr[i])
+		}
+	}
+	return out
+}
+
+type filterSelector struct {
+	path    []jsonStep
+	op      string // empty for existence checks
+	operand any
+}
+
+func (sel filterSelector) selectFrom(node any) []any {
+	out := make([]any, 0)
+	for _, child := range children(node) {
+		if sel.matches(child) {
+			out = append(out, child)
+		}
+	}
+	return out
+}
+
+func (sel filterSelector) matches(node any) bool {
+	values := evalJSONSteps(sel.path, node)
+	if sel.op == "" {
+		return len(values) > 0
+	}
+	for _, v := range values {
+		if compareJSON(v, sel.op, sel.operand) {
+			return true
+		}
+	}
+	return false
+}
+
+func compareJSON(v any, op string, operand any) bool {
+	if a, ok := toFloat(v); ok {
+		if b, ok := toFloat(operand); ok {
+			switch op {
+			case "==":
+				return a == b
+			case "!=":
+				return a != b
+			case "<":
+				return a < b
+			case "<=":
+				return a <= b
+			case ">":
+				return a > b
+			case ">=":
+				return a >= b
+			}
+			return false
+		}
+	}
+
+	a, b := RenderJSONValue(v), RenderJSONValue(operand)
+	switch op {
+	case "==":
+		return a == b
+	case "!=":
+		return a != b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	}
+	return false
+}
+
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+func parseJSONSteps(s string) ([]jsonStep, error) {
+	steps := make([]jsonStep, 0)
+	for len(s) > 0 {
+		var step jsonStep
+		switch {
+		case strings.HasPrefix(s, ".."):
+			step.recursive = true
+			s = s[2:]
+			if strings.HasPrefix(s, "[") {
+				sel, rest, err := parseBracket(s)
+				if err != nil {
+					return nil, err
+				}
+				step.selector, s = sel, rest
+			} else {
+				sel, rest, err := parseDotName(s)
+				if err != nil {
+					return nil, err
+				}
+				step.selector, s = sel, rest
+			}
+
+		case strings.HasPrefix(s, "."):
+			sel, rest, err := parseDotName(s[1:])
+			if err != nil {
+				return nil, err
+			}
+			step.selector, s = sel, rest
+
+		case strings.HasPrefix(s, "["):
+			sel, rest, err := parseBracket(s)
+			if err != nil {
+				return nil, err
+			}
+			step.selector, s = sel, rest
+
+		default:
+			return nil, fmt.Errorf("unexpected %q", s)
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+func parseDotName(s string) (jsonSelector, string, error) {
+	if strings.HasPrefix(s, "*") {
+		return wildcardSelector{}, s[1:], nil
+	}
+	end := strings.IndexAny(s, ".[")
+	if end < 0 {
+		end = len(s)
+	}
+	name := strings.TrimSpace(s[:end])
+	if name == "" {
+		return nil, "", fmt.Errorf("missing member name")
+	}
+	return nameSelector{[]string{name}}, s[end:], nil
+}
+
+func parseBracket(s string) (jsonSelector, string, error) {
+	end, err := matchingBracket(s)
+	if err != nil {
+		return nil, "", err
+	}
+	inner, rest := strings.TrimSpace(s[1:end]), s[end+1:]
+
+	switch {
+	case inner == "*":
+		return wildcardSelector{}, rest, nil
+
+	case strings.HasPrefix(inner, "?"):
+		sel, err := parseFilter(inner[1:])
+		return sel, rest, err
+	}
+
+	parts := splitOutsideQuotes(inner, ',')
+	if len(parts) == 1 && strings.Contains(inner, ":") && !isQuoted(inner) {
+		sel, err := parseSlice(inner)
+		return sel, rest, err
+	}
+
+	names := make([]string, 0)
+	indices := make([]int, 0)
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if isQuoted(part) {
+			names = append(names, part[1:len(part)-1])
+			continue
+		}
+		i, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid subscript %q", part)
+		}
+		indices = append(indices, i)
+	}
+	switch {
+	case len(names) > 0 && len(indices) > 0:
+		return nil, "", fmt.Errorf("cannot mix names and indices in %q", inner)
+	case len(names) > 0:
+		return nameSelector{names}, rest, nil
+	default:
+		return indexSelector{indices}, rest, nil
+	}
+}
+
+func parseSlice(s string) (jsonSelector, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return nil, fmt.Errorf("invalid slice %q", s)
+	}
+	bound := func(p string) (*int, error) {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			return nil, nil
+		}
+		i, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid slice bound %q", p)
+		}
+		return &i, nil
+	}
+
+	sel := sliceSelector{step: 1}
+	var err error
+	if sel.start, err = bound(parts[0]); err != nil {
+		return nil, err
+	}
+	if sel.end, err = bound(parts[1]); err != nil {
+		return nil, err
+	}
+	if len(parts) == 3 {
+		step, err := bound(parts[2])
+		if err != nil {
+			return nil, err
+		}
+		if step != nil {
+			sel.step = *step
+		}
+	}
+	if sel.step == 0 {
+		return nil, fmt.Errorf("slice step cannot be zero")
+	}
+	return sel, nil
+}
+
+var filterOperators = []string{"==", "!=", "<=", ">=", "<", ">"}
+
+func parseFilter(s string) (jsonSelector, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "(") || !strings.HasSuffix(s, ")") {
+		return nil, fmt.Errorf("filter must be wrapped in parentheses: %q", s)
+	}
+	s = strings.TrimSpace(s[1 : len(s)-1])
+	if !strings.HasPrefix(s, "@") {
+		return nil, fmt.Errorf("filter must start with @: %q", s)
+	}
+
+	lhs, op, rhs := s, "", ""
+	for _, candidate := range filterOperators {
+		if i := indexOutsideQuotes(s, candidate); i >= 0 {
+			lhs, op, rhs = strings.TrimSpace(s[:i]), candidate, strings.TrimSpace(s[i+len(candidate):])
+			break
+		}
+	}
+
+	path, err := parseJSONSteps(lhs[1:])
+	if err != nil {
+		return nil, err
+	}
+	sel := filterSelector{path: path, op: op}
+	if op != "" {
+		dec := json.NewDecoder(strings.NewReader(jsonLiteral(rhs)))
+		dec.UseNumber()
+		if err := dec.Decode(&sel.operand); err != nil {
+			return nil, fmt.Errorf("invalid filter operand %q", rhs)
+		}
+	}
+	return sel, nil
+}
+
+// jsonLiteral rewrites single-quoted strings, which JSONPath allows, into JSON strings.
+func jsonLiteral(s string) string {
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		b, _ := json.Marshal(s[1 : len(s)-1])
+		return string(b)
+	}
+	return s
+}
+
+func matchingBracket(s string) (int, error) {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+			if depth == 0 {
+				return i, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("unterminated bracket in %q", s)
+}
+
+func splitOutsideQuotes(s string, sep byte) []string {
+	parts := make([]string, 0)
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func indexOutsideQuotes(s, substr string) int {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case strings.HasPrefix(s[i:], substr):
+			return i
+		}
+	}
+	return -1
+}
+
+func isQuoted(s string) bool {
+	return len(s) >= 2 && (s[0] == '\'' || s[0] == '"') && s[len(s)-1] == s[0]
+}
+
+// DecodeJSON decodes a JSON document, preserving numbers exactly as they were written.
+func DecodeJSON(body []byte) (any, error) {
+	var doc any
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// RenderJSONValue renders a matched value as text: strings verbatim, anything else as compact JSON with sorted keys.
+func RenderJSONValue(v any) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return ""
+	}
+	return strings.TrimRight(buf.String(), "\n")
+}
+
+// SelectJSONText evaluates path against doc and renders the matches as canonical text, one match per line.
+func SelectJSONText(doc any, path *JSONPath) string {
+	return strings.Join(SelectAllJSONText(doc, path), "\n")
+}
+
+// SelectAllJSONText evaluates path against doc and renders each match as canonical text.
+func SelectAllJSONText(doc any, path *JSONPath) []string {
+	matches := path.Select(doc)
+	texts := make([]string, len(matches))
+	for i, m := range matches {
+		texts[i] = RenderJSONValue(m)
+	}
+	return texts
+}
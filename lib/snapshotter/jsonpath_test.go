@@ -0,0 +1,164 @@
+package snapshotter
+
+import (
+	"reflect"
+	"testing"
+)
+
+const storeJSON = `{
+	"store": {
+		"book": [
+			{"title": "Sayings", "price": 8.95, "isbn": "0-553"},
+			{"title": "Sword", "price": 12.99},
+			{"title": "Moby Dick", "price": 8.99, "isbn": "0-395"},
+			{"title": "Rings", "price": 22.99}
+		],
+		"bicycle": {"color": "red", "price": 19.95}
+	},
+	"name": "it's here"
+}`
+
+func TestJSONPathSelect(t *testing.T) {
+	doc, err := DecodeJSON([]byte(storeJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		expr string
+		want []string
+	}{
+		{"$.name", []string{"it's here"}},
+		{"$['name']", []string{"it's here"}},
+		{"$.missing", []string{}},
+		{"$.store.bicycle.color", []string{"red"}},
+		{"$.store.bicycle.*", []string{"red", "19.95"}},
+		{"$.store.book[0].title", []string{"Sayings"}},
+		{"$.store.book[-1].title", []string{"Rings"}},
+		{"$.store.book[9].title", []string{}},
+		{"$.store.book[*].price", []string{"8.95", "12.99", "8.99", "22.99"}},
+
+		// Unions
+		{"$.store.book[0,2].title", []string{"Sayings", "Moby Dick"}},
+		{"$.store.bicycle['color','price']", []string{"red", "19.95"}},
+
+		// Slices
+		{"$.store.book[1:3].title", []string{"Sword", "Moby Dick"}},
+		{"$.store.book[:2].title", []string{"Sayings", "Sword"}},
+		{"$.store.book[-2:].title", []string{"Moby Dick", "Rings"}},
+		{"$.store.book[::2].title", []string{"Sayings", "Moby Dick"}},
+		{"$.store.book[::-1].title", []string{"Rings", "Moby Dick", "Sword", "Sayings"}},
+		{"$.store.book[5:9].title", []string{}},
+
+		// Recursive descent
+		{"$..color", []string{"red"}},
+		{"$..isbn", []string{"0-553", "0-395"}},
+		{"$..book[1].title", []string{"Sword"}},
+
+		// Filters
+		{"$.store.book[?(@.isbn)].title", []string{"Sayings", "Moby Dick"}},
+		{"$.store.book[?(@.price < 10)].title", []string{"Sayings", "Moby Dick"}},
+		{"$.store.book[?(@.price >= 12.99)].title", []string{"Sword", "Rings"}},
+		{"$.store.book[?(@.title == 'Rings')].price", []string{"22.99"}},
+		{`$.store.book[?(@.title != "Rings")].price`, []string{"8.95", "12.99", "8.99"}},
+		{"$..book[?(@.price > 100)]", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			path, err := CompileJSONPath(tt.expr)
+			if err != nil {
+				t.Fatalf("CompileJSONPath(%q): %v", tt.expr, err)
+			}
+			got := SelectAllJSONText(doc, path)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Select(%q) = %q, want %q", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
+
+// The slice examples of RFC 9535, section 2.3.4.3, and their edge cases as Python slices them.
+func TestJSONPathSlices(t *testing.T) {
+	doc, err := DecodeJSON([]byte(`["a", "b", "c", "d", "e", "f", "g"]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		expr string
+		want []string
+	}{
+		{"$[1:3]", []string{"b", "c"}},
+		{"$[5:]", []string{"f", "g"}},
+		{"$[1:5:2]", []string{"b", "d"}},
+		{"$[5:1:-2]", []string{"f", "d"}},
+		{"$[::-1]", []string{"g", "f", "e", "d", "c", "b", "a"}},
+		{"$[:]", []string{"a", "b", "c", "d", "e", "f", "g"}},
+		{"$[-3:]", []string{"e", "f", "g"}},
+		{"$[-1:]", []string{"g"}},
+		{"$[-10:2]", []string{"a", "b"}},
+		{"$[-10:10]", []string{"a", "b", "c", "d", "e", "f", "g"}},
+		{"$[::3]", []string{"a", "d", "g"}},
+		{"$[3:3]", []string{}},
+		{"$[3:1]", []string{}},
+
+		// Stepping backwards, bounds are clamped to one before the array rather than to its start
+		{"$[2:-10:-1]", []string{"c", "b", "a"}},
+		{"$[10:0:-1]", []string{"g", "f", "e", "d", "c", "b"}},
+		{"$[-1:-4:-1]", []string{"g", "f", "e"}},
+		{"$[6:-8:-2]", []string{"g", "e", "c", "a"}},
+		{"$[::-3]", []string{"g", "d", "a"}},
+		{"$[::-10]", []string{"g"}},
+		{"$[-8::-1]", []string{}},
+		{"$[1:3:-1]", []string{}},
+		{"$[0:1:-1]", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			path, err := CompileJSONPath(tt.expr)
+			if err != nil {
+				t.Fatalf("CompileJSONPath(%q): %v", tt.expr, err)
+			}
+			got := SelectAllJSONText(doc, path)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Select(%q) = %q, want %q", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileJSONPathRejectsMalformed(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"store.book",
+		"$.",
+		"$[",
+		"$.book[0",
+		"$.book[x]",
+		"$.book['a',0]",
+		"$.book[1:2:3:4]",
+		"$.book[::0]",
+		"$.book[a:b]",
+		"$.book[?@.price]",
+		"$.book[?(price > 1)]",
+		"$.book[?(@.price > nope)]",
+		"$name",
+	} {
+		t.Run(expr, func(t *testing.T) {
+			if _, err := CompileJSONPath(expr); err == nil {
+				t.Errorf("CompileJSONPath(%q) succeeded, want an error", expr)
+			}
+		})
+	}
+}
+
+func TestRenderJSONValue(t *testing.T) {
+	doc, err := DecodeJSON([]byte(`{"b": [1, 2.50], "a": {"y": true, "x": null}, "s": "<tag>"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"a":{"x":null,"y":true},"b":[1,2.50],"s":"<tag>"}`
+	if got := RenderJSONValue(doc); got != want {
+		t.Errorf("RenderJSONValue() = %s, want %s", got, want)
+	}
+}
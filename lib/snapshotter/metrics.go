@@ -11,4 +11,5 @@ func (m *snapshotMetrics) Add(other *snapshotMetrics) {
 	m.totalSelected += other.totalSelected
 	m.updated += other.updated
 	m.unchanged += other.unchanged
+	m.errored += other.errored
 }
@@ -2,17 +2,49 @@ package snapshotter
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
 
+	"github.com/fiffu/diffwatch/lib/diff"
 	"github.com/fiffu/diffwatch/lib/models"
+	"github.com/fiffu/diffwatch/lib/proxies"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
 
-func (s *Snapshotter) pollSnapshots(ctx context.Context, batchStartTIme time.Time) {
-	m := s.findSubscriptionsForPoll(ctx, batchStartTIme, s.collectBatch)
+// runDueJobs enqueues due polls, then claims and runs due jobs until none are left or the run times out.
+func (s *Snapshotter) runDueJobs(stopping context.Context, runStartTime time.Time) {
+	ctx, cancel := context.WithTimeout(stopping, s.runTimeout)
+	defer cancel()
+
+	if _, err := s.Intervals(ctx); err != nil {
+		s.log.Sugar().Errorw("Failed to load intervals, keeping the last loaded", "err", err)
+	}
+	if err := s.enqueuePolls(ctx, runStartTime); err != nil {
+		s.log.Sugar().Errorw("Failed to enqueue due polls", "err", err)
+	}
+
+	stopRenewing := s.renewLeases()
+	defer stopRenewing()
+
+	jobs := make(chan *models.Job)
+	go s.claimDueJobs(ctx, jobs)
+
+	m := &snapshotMetrics{}
+	errs := make([]error, 0)
+	for result := range s.runPool(jobs) {
+		m.totalSelected += 1
+		m.Add(result.metrics)
+		if result.err != nil {
+			errs = append(errs, fmt.Errorf("%s job for target %d: %w", result.job.Kind, result.job.TargetID, result.err))
+		}
+	}
+	if len(errs) > 0 {
+		s.log.Sugar().Warnf("snapshot: job errors: %+v", errs)
+	}
 
 	if m.totalSelected > 0 {
 		args := make([]any, 0)
@@ -27,88 +59,182 @@ func (s *Snapshotter) pollSnapshots(ctx context.Context, batchStartTIme time.Tim
 		}
 
 		s.log.Sugar().Infow(
-			fmt.Sprintf("Processed %d subscriptions", m.totalSelected),
+			fmt.Sprintf("Processed %d jobs", m.totalSelected),
 			args...,
 		)
 	}
 
-	s.purgeOldSnapshots(ctx, batchStartTIme)
+	s.sendDueDigests(stopping, runStartTime)
+	s.purgeOldSnapshots(ctx, runStartTime)
 
-	elapsed := time.Now().UTC().Sub(batchStartTIme)
+	elapsed := time.Now().UTC().Sub(runStartTime)
 	s.log.Sugar().Infow("Snapshotter completed", "elapsed_msecs", int(elapsed.Milliseconds()))
 }
 
-func (s *Snapshotter) findSubscriptionsForPoll(
-	ctx context.Context,
-	batchStartTime time.Time,
-	callbackPerBatch func(context.Context, models.Subscriptions, time.Time) (*snapshotMetrics, []error),
-) *snapshotMetrics {
-	lastPollCutoff := batchStartTime.Add(-s.pollInterval)
-	noContentCutoff := batchStartTime.Add(-s.noContentTTL)
-
-	var subs models.Subscriptions
-	var metrics = &snapshotMetrics{}
-	tx := s.db.
-		Where("no_content_since IS NULL OR no_content_since > ?", noContentCutoff).
-		Where("last_poll_time IS NULL OR last_poll_time <= ?", lastPollCutoff).
-		InnerJoins("Notifier").
-		FindInBatches(&subs, s.concurrency, func(tx *gorm.DB, batch int) error {
-			batchMetrics, errs := callbackPerBatch(ctx, subs, batchStartTime)
-			if len(errs) > 0 {
-				s.log.Sugar().Warnf("snapshot: batch errors: %+v", errs)
+// claimDueJobs hands out due jobs as workers become free, releasing any it couldn't, then closes out.
+func (s *Snapshotter) claimDueJobs(ctx context.Context, out chan<- *models.Job) {
+	defer close(out)
+	for ctx.Err() == nil {
+		jobs, err := s.claimJobs(ctx, time.Now().UTC(), s.concurrency)
+		if err != nil {
+			s.log.Sugar().Errorw("Failed to claim jobs", "err", err)
+			return
+		}
+		if len(jobs) == 0 {
+			return
+		}
+		for i, job := range jobs {
+			select {
+			case out <- job:
+			case <-ctx.Done():
+				s.releaseJobs(jobs[i:])
+				return
 			}
-
-			metrics.totalSelected += len(subs)
-			metrics.Add(batchMetrics)
-
-			return nil
-		})
-	if err := tx.Error; err != nil {
-		s.log.Sugar().Infof("Failed to fetch pollable subscriptions, err: %v", err)
+		}
 	}
+}
 
-	return metrics
+// jobResult is the outcome of running a job on the worker pool.
+type jobResult struct {
+	job     *models.Job
+	metrics *snapshotMetrics
+	err     error
 }
 
-func (s *Snapshotter) collectBatch(ctx context.Context, batch models.Subscriptions, batchStartTime time.Time) (*snapshotMetrics, []error) {
-	var wg sync.WaitGroup
-	var metrics = &snapshotMetrics{}
+// runPool runs the jobs on s.concurrency goroutines, closing the results once all have finished.
+func (s *Snapshotter) runPool(jobs <-chan *models.Job) <-chan jobResult {
+	results := make(chan jobResult)
 
-	errs := make([]error, 0)
-	for _, sub := range batch {
+	var wg sync.WaitGroup
+	for range s.concurrency {
 		wg.Add(1)
-
 		go func() {
 			defer wg.Done()
-			m, err := s.snapshotAndNotify(ctx, sub)
-			if err != nil {
-				errs = append(errs, err)
+			for job := range jobs {
+				results <- s.runJobWithDeadline(job)
 			}
-			metrics.Add(m)
 		}()
 	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
+
+// runJobWithDeadline runs the job with its own deadline, independent of the run's and of stopping.
+func (s *Snapshotter) runJobWithDeadline(job *models.Job) jobResult {
+	ctx, cancel := context.WithTimeout(context.Background(), s.jobTimeout)
+	defer cancel()
+
+	m, err := s.runJob(ctx, job)
+	if m == nil {
+		m = &snapshotMetrics{}
+	}
+	return jobResult{job, m, err}
+}
 
-	tx := s.db.Model(&batch).Update("last_poll_time", batchStartTime)
-	if err := tx.Error; err != nil {
-		errs = append(errs, err)
+// runJob polls the job's target for the subscriptions it is due for, then removes the job even if the poll failed.
+func (s *Snapshotter) runJob(ctx context.Context, job *models.Job) (*snapshotMetrics, error) {
+	defer s.completeJob(job)
+	if job.Attempts > 1 {
+		s.log.Sugar().Warnw("Running job again after its lease expired", "job_id", job.ID, "kind", job.Kind, "target_id", job.TargetID, "attempts", job.Attempts)
 	}
 
-	wg.Wait()
-	return metrics, errs
+	now := time.Now().UTC()
+	target := &models.Target{}
+	tx := s.db.First(target, job.TargetID)
+	switch {
+	case errors.Is(tx.Error, gorm.ErrRecordNotFound):
+		return &snapshotMetrics{}, nil
+	case tx.Error != nil:
+		return &snapshotMetrics{errored: 1}, tx.Error
+	}
+	scopes := []func(*gorm.DB) *gorm.DB{s.polled(now)}
+	if job.Kind == models.JobPoll {
+		// Subscribers that aren't due keep to their own schedules
+		scopes = append(scopes, due(now))
+	}
+	subs, err := s.subscribersOf(ctx, target, scopes...)
+	switch {
+	case err != nil:
+		return &snapshotMetrics{errored: 1}, err
+	case len(subs) == 0:
+		// Deleted, paused or polled since the job was scheduled
+		return &snapshotMetrics{}, nil
+	}
+
+	switch job.Kind {
+	case models.JobChase:
+		return s.chase(ctx, target, subs)
+	default:
+		for _, sub := range subs {
+			if err := s.scheduleNextPoll(ctx, sub, now); err != nil {
+				return &snapshotMetrics{errored: 1}, err
+			}
+		}
+		return s.pollTarget(ctx, target, subs)
+	}
 }
 
-func (s *Snapshotter) snapshotAndNotify(ctx context.Context, sub *models.Subscription) (*snapshotMetrics, error) {
-	var m = &snapshotMetrics{}
-	var errMetric = &snapshotMetrics{errored: 1}
+// scheduleNextPoll records the poll and when the subscription is next due, or the default interval if unplannable.
+func (s *Snapshotter) scheduleNextPoll(ctx context.Context, sub *models.Subscription, pollTime time.Time) error {
+	plan, err := s.PlanNextPoll(ctx, sub, pollTime)
+	if err != nil {
+		s.log.Sugar().Errorw("Unable to plan next poll, polling at the default interval", "subscription_id", sub.ID, "schedule", sub.Schedule, "err", err)
+		interval := s.pollInterval()
+		plan = PollPlan{pollTime.Add(interval), interval, "unable to plan, using the default interval"}
+	}
+	tx := s.db.Model(&models.Subscription{}).Where("id = ?", sub.ID).Updates(map[string]any{
+		"last_poll_time":     pollTime,
+		"next_poll_at":       plan.At,
+		"effective_interval": plan.Interval,
+		"interval_reason":    plan.Reason,
+	})
+	return tx.Error
+}
 
-	content, err := s.GetEndpointContent(ctx, sub.Endpoint, sub.XPath)
+// pollTarget fetches the target once and snapshots it for each subscription.
+func (s *Snapshotter) pollTarget(ctx context.Context, target *models.Target, subs models.Subscriptions) (*snapshotMetrics, error) {
+	content, err := s.GetEndpointContent(ctx, target, sharedValidators(subs))
 	if err != nil {
-		s.log.Sugar().Errorw("error collecting snapshot", "err", err)
-		return errMetric, err
+		s.log.Sugar().Errorw("error collecting snapshot", "target_id", target.ID, "err", err)
+		for _, sub := range subs {
+			s.recordFailure(ctx, sub, content.StatusCode, err)
+		}
+		return &snapshotMetrics{errored: len(subs)}, err
 	}
 
+	m := &snapshotMetrics{}
+	errs := make([]error, 0)
+	for _, sub := range subs {
+		subMetrics, err := s.snapshotAndNotify(ctx, sub, content)
+		m.Add(subMetrics)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("subscription %d: %w", sub.ID, err))
+		}
+	}
+	return m, errors.Join(errs...)
+}
+
+// snapshotAndNotify snapshots the content fetched for the subscription, and notifies the subscriber if it changed.
+func (s *Snapshotter) snapshotAndNotify(ctx context.Context, sub *models.Subscription, content *models.EndpointContent) (*snapshotMetrics, error) {
+	var m = &snapshotMetrics{}
+	var errMetric = &snapshotMetrics{errored: 1}
+
+	s.recordSuccess(ctx, sub, content.StatusCode)
+
 	requestedAt := time.Now().UTC()
 
+	if content.NotModified {
+		if err := s.handleNotModified(ctx, sub, requestedAt); err != nil {
+			s.log.Sugar().Errorw("error handling unmodified snapshot", "err", err)
+			return errMetric, err
+		}
+		m.unchanged += 1
+		return m, nil
+	}
+
 	isChanged, err := s.handleContent(ctx, sub, requestedAt, content)
 	switch {
 	case err != nil:
@@ -120,16 +246,17 @@ func (s *Snapshotter) snapshotAndNotify(ctx context.Context, sub *models.Subscri
 		m.unchanged += 1
 	}
 
-	if content.Text != "" {
-		if err := s.handleEmptyContent(ctx, sub, requestedAt); err != nil {
-			return errMetric, err
-		}
+	if err := s.handleEmptyContent(ctx, sub, requestedAt, content.Text == ""); err != nil {
+		return errMetric, err
+	}
+	if err := s.saveCacheValidators(ctx, sub, content.CacheValidators); err != nil {
+		return errMetric, err
 	}
 	return m, err
 }
 
 func (s *Snapshotter) handleContent(ctx context.Context, sub *models.Subscription, timestamp time.Time, content *models.EndpointContent) (isChanged bool, err error) {
-	currDigest := models.DigestContent(content.Text)
+	currDigest := content.Digest()
 
 	var firstSeen bool
 	var prevSnap models.Snapshot
@@ -142,8 +269,7 @@ func (s *Snapshotter) handleContent(ctx context.Context, sub *models.Subscriptio
 	case nil:
 		if prevSnap.ContentDigest == currDigest {
 			// Not changed
-			tx := s.db.Model(&prevSnap).Where("timestamp = ?", prevSnap.Timestamp).Update("timestamp", timestamp)
-			err = tx.Error
+			err = s.touchSnapshot(ctx, &prevSnap, timestamp)
 			return
 		}
 	default:
@@ -152,49 +278,173 @@ func (s *Snapshotter) handleContent(ctx context.Context, sub *models.Subscriptio
 		return
 	}
 
+	var prev *models.Snapshot
+	if !firstSeen {
+		prev = &prevSnap
+	}
+
 	isChanged = true
 	newSnap := models.Snapshot{
 		Timestamp:      timestamp,
+		CapturedAt:     sql.NullTime{Time: timestamp, Valid: true},
 		UserID:         sub.UserID,
 		SubscriptionID: sub.ID,
 		Content:        content.Text,
+		Items:          content.Items,
+		Entries:        content.Entries,
 		ContentDigest:  currDigest,
 	}
+	s.ArchiveRaw(&newSnap, content.Raw)
+	if prev != nil {
+		newSnap.Diff = diff.Compute(prev.Content, newSnap.Content)
+		newSnap.Reverted = s.isFlapping(sub, currDigest, timestamp)
+	}
+	notify := !newSnap.Reverted && s.shouldNotify(sub, prev, &newSnap)
+
 	tx2 := s.db.Clauses(clause.Returning{}).Create(&newSnap)
 	if err = tx2.Error; err != nil {
 		return
 	}
-
-	p := &prevSnap
-	if firstSeen {
-		p = nil
+	if prev != nil {
+		if err = s.recordDigest(ctx, sub, prev.ContentDigest, timestamp); err != nil {
+			return
+		}
 	}
-	if err = s.SendSnapshot(ctx, sub, p, &newSnap); err != nil {
-		s.log.Sugar().Errorw("Failed to send snapshot", "err", err)
-		return
+
+	if notify {
+		if err = s.notify(ctx, sub, prev, &newSnap); err != nil {
+			s.log.Sugar().Errorw("Failed to notify snapshot", "err", err)
+			return
+		}
 	}
 
-	chaser := models.Chaser{
-		SubscriptionID: sub.ID,
-		NotifierID:     sub.NotifierID,
-		NotBefore:      timestamp.Add(s.chaseInterval),
+	if newSnap.Reverted {
+		// Chasing a page that flips between states would keep chasing it
+		return
 	}
-	tx3 := s.db.Create(&chaser)
-	if err = tx3.Error; err != nil {
-		s.log.Sugar().Errorw("Failed to schedule chaser", "subscription_id", sub.ID, "err", err)
+	if err = s.scheduleChase(ctx, sub.TargetID, timestamp.Add(s.chaseInterval())); err != nil {
+		s.log.Sugar().Errorw("Failed to schedule chase", "subscription_id", sub.ID, "err", err)
 		return
 	}
 	return
 }
 
-func (s *Snapshotter) handleEmptyContent(ctx context.Context, sub *models.Subscription, timestamp time.Time) error {
-	if sub.NoContentSince.Valid {
-		// Don't do anything if we already observed empty content on this subscription
-		return nil
+// isFlapping reports whether the content went back to one it changed away from within the flap window.
+func (s *Snapshotter) isFlapping(sub *models.Subscription, digest string, timestamp time.Time) bool {
+	return s.flapWindow > 0 && sub.RecentDigests.SeenSince(digest, timestamp.Add(-s.flapWindow))
+}
+
+// recordDigest remembers that the subscription's content changed away from the given digest.
+func (s *Snapshotter) recordDigest(ctx context.Context, sub *models.Subscription, digest string, timestamp time.Time) error {
+	sub.RecentDigests = sub.RecentDigests.Push(digest, timestamp)
+	tx := s.db.Model(sub).Update("recent_digests", sub.RecentDigests)
+	return tx.Error
+}
+
+// shouldNotify decides whether a change is worth notifying, recording the rule that fired, if any.
+func (s *Snapshotter) shouldNotify(sub *models.Subscription, prev, curr *models.Snapshot) bool {
+	if curr.IsFeed() && prev != nil {
+		if added, edited := models.DiffFeedEntries(prev.Entries, curr.Entries); len(added) == 0 && len(edited) == 0 {
+			// Entries only dropped off the end of the feed
+			return false
+		}
+	}
+	if len(sub.Rules) == 0 {
+		return true
+	}
+	rule := sub.Rules.Evaluate(prev, curr)
+	if rule == nil {
+		return false
+	}
+	curr.FiredRule = rule.String()
+	return true
+}
+
+// handleNotModified records that the server confirmed the content is unchanged since our last poll.
+func (s *Snapshotter) handleNotModified(ctx context.Context, sub *models.Subscription, timestamp time.Time) error {
+	var prevSnap models.Snapshot
+	tx := s.db.Where("subscription_id = ?", sub.ID).Order("timestamp desc").First(&prevSnap)
 
-	} else {
+	switch tx.Error {
+	case gorm.ErrRecordNotFound:
+		// We have nothing to compare against, so forget the validators and fetch the full page next time
+		return s.saveCacheValidators(ctx, sub, models.CacheValidators{})
+	case nil:
+		return s.touchSnapshot(ctx, &prevSnap, timestamp)
+	default:
+		return tx.Error
+	}
+}
+
+// touchSnapshot moves the timestamp of an unchanged snapshot forward, marking when its content was last seen.
+func (s *Snapshotter) touchSnapshot(ctx context.Context, snap *models.Snapshot, timestamp time.Time) error {
+	tx := s.db.Model(snap).
+		Where("subscription_id = ? AND timestamp = ?", snap.SubscriptionID, snap.Timestamp).
+		Update("timestamp", timestamp)
+	return tx.Error
+}
+
+// handleEmptyContent tracks since when the subscription's selector has matched nothing.
+func (s *Snapshotter) handleEmptyContent(ctx context.Context, sub *models.Subscription, timestamp time.Time, isEmpty bool) error {
+	switch {
+	case isEmpty && !sub.NoContentSince.Valid:
 		tx := s.db.Model(sub).Update("no_content_since", timestamp)
 		return tx.Error
+
+	case !isEmpty && sub.NoContentSince.Valid:
+		tx := s.db.Model(sub).Update("no_content_since", nil)
+		return tx.Error
+
+	default:
+		// Don't do anything if the mark is already up to date
+		return nil
+	}
+}
+
+// recordFailure saves why the poll failed, pausing the subscription after too many failures not due to backoff.
+func (s *Snapshotter) recordFailure(ctx context.Context, sub *models.Subscription, status int, cause error) {
+	if proxies.IsProxyError(cause) {
+		tx := s.db.Model(sub).Update("last_proxy_error", cause.Error())
+		if err := tx.Error; err != nil {
+			s.log.Sugar().Errorw("Failed to record proxy failure", "subscription_id", sub.ID, "err", err)
+		}
+		return
+	}
+
+	updates := map[string]any{
+		"last_error":       cause.Error(),
+		"last_http_status": status,
+	}
+	if backoff := new(HostBackoffError); !errors.As(cause, &backoff) {
+		sub.ConsecutiveFailures += 1
+		updates["consecutive_failures"] = sub.ConsecutiveFailures
+
+		if s.pauseAfterFailures > 0 && sub.ConsecutiveFailures >= s.pauseAfterFailures {
+			updates["paused_at"] = time.Now().UTC()
+			s.log.Sugar().Warnw("Pausing subscription after repeated failures",
+				"subscription_id", sub.ID, "consecutive_failures", sub.ConsecutiveFailures, "err", cause)
+		}
+	}
+
+	tx := s.db.Model(sub).Updates(updates)
+	if err := tx.Error; err != nil {
+		s.log.Sugar().Errorw("Failed to record subscription failure", "subscription_id", sub.ID, "err", err)
+	}
+}
+
+// recordSuccess clears the subscription's failure state after a successful poll.
+func (s *Snapshotter) recordSuccess(ctx context.Context, sub *models.Subscription, status int) {
+	if sub.ConsecutiveFailures == 0 && sub.LastError == "" && sub.LastProxyError == "" && sub.LastHTTPStatus == status {
+		return
+	}
+	tx := s.db.Model(sub).Updates(map[string]any{
+		"last_error":           "",
+		"last_proxy_error":     "",
+		"last_http_status":     status,
+		"consecutive_failures": 0,
+	})
+	if err := tx.Error; err != nil {
+		s.log.Sugar().Errorw("Failed to record subscription success", "subscription_id", sub.ID, "err", err)
 	}
 }
 
@@ -207,6 +457,7 @@ func (s *Snapshotter) purgeOldSnapshots(ctx context.Context, batchStartTime time
 	}
 	if tx.RowsAffected > 0 {
 		s.log.Sugar().Infof("Purged %d old snapshots", tx.RowsAffected)
+		s.sweepArchive(batchStartTime)
 	}
 	return
 }
@@ -0,0 +1,361 @@
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fiffu/diffwatch/lib/blobs"
+	"github.com/fiffu/diffwatch/lib/models"
+	"github.com/fiffu/diffwatch/senders"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// fakeSender records the changes it is asked to send.
+type fakeSender struct {
+	mu        sync.Mutex
+	sent      map[uint][]string   // Content of the snapshots sent, by subscription
+	digests   map[uint][][]string // Content of the current snapshots in each digest sent, by notifier
+	digestErr error               // Returned by SendDigest, if set
+}
+
+func (f *fakeSender) SendSnapshot(ctx context.Context, notifier *models.Notifier, sub *models.Subscription, before, after *models.Snapshot) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent[sub.ID] = append(f.sent[sub.ID], after.Content)
+	return "", nil
+}
+
+func (f *fakeSender) SendDigest(ctx context.Context, notifier *models.Notifier, pending models.PendingNotifications) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.digestErr != nil {
+		return "", f.digestErr
+	}
+	var digest []string
+	for _, p := range pending {
+		digest = append(digest, p.Change.Current.Content)
+	}
+	f.digests[notifier.ID] = append(f.digests[notifier.ID], digest)
+	return "", nil
+}
+
+func (f *fakeSender) SendVerification(ctx context.Context, notifier *models.Notifier, verifyURL string) (string, error) {
+	return "", nil
+}
+
+func (f *fakeSender) sentTo(sub *models.Subscription) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.sent[sub.ID]
+}
+
+func (f *fakeSender) digestsTo(notifierID uint) [][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.digests[notifierID]
+}
+
+// page serves <p> tags with the given text, tagged with an ETag that changes with it, and answers 304 to requests
+// for the text it already serves.
+type page struct {
+	mu       sync.Mutex
+	text     string
+	status   int
+	requests atomic.Int32
+}
+
+func (p *page) set(text string, status int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.text, p.status = text, status
+}
+
+func (p *page) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.requests.Add(1)
+	p.mu.Lock()
+	text, status := p.text, p.status
+	p.mu.Unlock()
+
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+		return
+	}
+	etag := fmt.Sprintf("%q", text)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("ETag", etag)
+	fmt.Fprintf(w, "<html><body><p>%s</p></body></html>", text)
+}
+
+func newPage(t *testing.T, text string) (*page, *httptest.Server) {
+	p := &page{text: text, status: http.StatusOK}
+	srv := httptest.NewServer(p)
+	t.Cleanup(srv.Close)
+	return p, srv
+}
+
+func newTestSnapshotter(t *testing.T) (*Snapshotter, *fakeSender) {
+	dsn := filepath.Join(t.TempDir(), "test.sqlite") + "?_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.AutoMigrate(
+		&models.User{},
+		&models.Notifier{},
+		&models.Target{},
+		&models.Subscription{},
+		&models.Snapshot{},
+		&models.Job{},
+		&models.PendingNotification{},
+		&models.Setting{},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := blobs.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sender := &fakeSender{sent: make(map[uint][]string), digests: make(map[uint][][]string)}
+	intervals := Intervals{Poll: time.Hour, Chase: time.Minute}
+	s := &Snapshotter{
+		db:           db,
+		log:          zap.NewNop(),
+		transport:    http.DefaultTransport,
+		senders:      senders.Registry{"test": sender},
+		archive:      archive,
+		concurrency:  4,
+		workerID:     "test",
+		leaseTTL:     time.Minute,
+		stopped:      make(chan struct{}),
+		intervals:    &runtimeIntervals{defaults: intervals, Intervals: intervals},
+		noContentTTL: 24 * time.Hour,
+		snapshotTTL:  30 * 24 * time.Hour,
+		runTimeout:   10 * time.Second,
+		jobTimeout:   5 * time.Second,
+		hosts:        newHostScheduler(politenessConfig{maxConcurrent: 4}),
+	}
+	return s, sender
+}
+
+// subscribe adds a subscription to the <p> on the endpoint, which was last seen to hold the given text.
+func subscribe(t *testing.T, s *Snapshotter, endpoint, seen string) *models.Subscription {
+	notifier := &models.Notifier{Verified: true, Platform: "test"}
+	if err := s.db.Create(notifier).Error; err != nil {
+		t.Fatal(err)
+	}
+	spec := models.WatchSpec{Endpoint: endpoint, SelectorKind: models.SelectorCSS, XPath: "p"}
+	target, err := s.SaveTarget(context.Background(), &models.Target{WatchSpec: spec})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := &models.Subscription{NotifierID: notifier.ID, TargetID: target.ID, WatchSpec: spec}
+	sub.ETag = fmt.Sprintf("%q", seen)
+	if err := s.db.Create(sub).Error; err != nil {
+		t.Fatal(err)
+	}
+	snap := &models.Snapshot{Timestamp: time.Now().UTC(), SubscriptionID: sub.ID, Content: seen}
+	if err := s.db.Create(snap).Error; err != nil {
+		t.Fatal(err)
+	}
+	return sub
+}
+
+// runAgain makes every subscription due, and runs the due jobs.
+func runAgain(t *testing.T, s *Snapshotter) {
+	if err := s.db.Model(&models.Subscription{}).Where("1 = 1").Update("next_poll_at", nil).Error; err != nil {
+		t.Fatal(err)
+	}
+	s.runDueJobs(context.Background(), time.Now().UTC())
+}
+
+func TestRunDueJobs(t *testing.T) {
+	s, sender := newTestSnapshotter(t)
+	shared, sharedSrv := newPage(t, "two")
+	single, singleSrv := newPage(t, "one")
+
+	a := subscribe(t, s, sharedSrv.URL, "one")
+	b := subscribe(t, s, sharedSrv.URL, "one")
+	c := subscribe(t, s, singleSrv.URL, "one")
+
+	runAgain(t, s)
+
+	for _, sub := range []*models.Subscription{a, b} {
+		if got := sender.sentTo(sub); len(got) != 1 || got[0] != "two" {
+			t.Errorf("subscription %d was sent %q, want [two]", sub.ID, got)
+		}
+	}
+	if got := sender.sentTo(c); len(got) != 0 {
+		t.Errorf("unchanged subscription %d was sent %q", c.ID, got)
+	}
+	if n := shared.requests.Load(); n != 1 {
+		t.Errorf("shared target was fetched %d times, want once", n)
+	}
+	if n := single.requests.Load(); n != 1 {
+		t.Errorf("target was fetched %d times, want once", n)
+	}
+
+	var jobs models.Jobs
+	if err := s.db.Find(&jobs).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].Kind != models.JobChase || jobs[0].TargetID != a.TargetID {
+		t.Errorf("jobs left = %+v, want one chase of target %d", jobs, a.TargetID)
+	}
+}
+
+func TestRunDueJobsSharesTargetBetweenSubscribersWhoSawDifferentContent(t *testing.T) {
+	s, sender := newTestSnapshotter(t)
+	p, srv := newPage(t, "two")
+
+	seenNew := subscribe(t, s, srv.URL, "two")
+	seenOld := subscribe(t, s, srv.URL, "one")
+
+	// The validators differ, so the request can't be conditional, or the subscriber who saw the old content misses
+	// the change
+	runAgain(t, s)
+	if got := sender.sentTo(seenOld); len(got) != 1 || got[0] != "two" {
+		t.Errorf("subscription that saw the old content was sent %q, want [two]", got)
+	}
+	if got := sender.sentTo(seenNew); len(got) != 0 {
+		t.Errorf("subscription that saw the new content was sent %q", got)
+	}
+
+	// Both subscribers have now seen the same response, so the next request is conditional
+	runAgain(t, s)
+	if got := sender.sentTo(seenOld); len(got) != 1 {
+		t.Errorf("unchanged content was sent again: %q", got)
+	}
+	if n := p.requests.Load(); n != 2 {
+		t.Errorf("target was fetched %d times, want 2", n)
+	}
+
+	p.set("three", http.StatusOK)
+	runAgain(t, s)
+	for _, sub := range []*models.Subscription{seenNew, seenOld} {
+		if got := sender.sentTo(sub); len(got) == 0 || got[len(got)-1] != "three" {
+			t.Errorf("subscription %d was sent %q, want the last to be three", sub.ID, got)
+		}
+	}
+}
+
+func TestRunDueJobsPollsOnlyDueSubscribersOfSharedTarget(t *testing.T) {
+	s, sender := newTestSnapshotter(t)
+	p, srv := newPage(t, "one")
+	often := subscribe(t, s, srv.URL, "one")
+	daily := subscribe(t, s, srv.URL, "one")
+	for sub, schedule := range map[*models.Subscription]string{often: "5m", daily: "0 9 * * *"} {
+		if err := s.db.Model(sub).Update("schedule", schedule).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.runDueJobs(context.Background(), time.Now().UTC())
+	dailyBefore := &models.Subscription{}
+	if err := s.db.First(dailyBefore, daily.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the frequently polled subscription is due
+	p.set("two", http.StatusOK)
+	if err := s.db.Model(often).Update("next_poll_at", nil).Error; err != nil {
+		t.Fatal(err)
+	}
+	s.runDueJobs(context.Background(), time.Now().UTC())
+
+	if got := sender.sentTo(often); len(got) != 1 || got[0] != "two" {
+		t.Errorf("due subscription was sent %q, want [two]", got)
+	}
+	if got := sender.sentTo(daily); len(got) != 0 {
+		t.Errorf("subscription that wasn't due was sent %q", got)
+	}
+	dailyAfter := &models.Subscription{}
+	if err := s.db.First(dailyAfter, daily.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !dailyAfter.NextPollAt.Time.Equal(dailyBefore.NextPollAt.Time) || !dailyAfter.LastPollTime.Time.Equal(dailyBefore.LastPollTime.Time) {
+		t.Errorf("subscription that wasn't due was polled: next poll %s, was %s", dailyAfter.NextPollAt.Time, dailyBefore.NextPollAt.Time)
+	}
+}
+
+func TestRunDueJobsRecordsFailures(t *testing.T) {
+	s, sender := newTestSnapshotter(t)
+	p, srv := newPage(t, "one")
+	p.set("", http.StatusNotFound)
+
+	a := subscribe(t, s, srv.URL, "one")
+	b := subscribe(t, s, srv.URL, "one")
+	runAgain(t, s)
+
+	for _, sub := range []*models.Subscription{a, b} {
+		got := &models.Subscription{}
+		if err := s.db.First(got, sub.ID).Error; err != nil {
+			t.Fatal(err)
+		}
+		if got.ConsecutiveFailures != 1 || got.LastHTTPStatus != http.StatusNotFound || got.LastError == "" {
+			t.Errorf("subscription %d has %d failures, status %d, error %q; want one failure with status 404",
+				sub.ID, got.ConsecutiveFailures, got.LastHTTPStatus, got.LastError)
+		}
+		if !got.NextPollAt.Valid {
+			t.Errorf("subscription %d has no next poll scheduled", sub.ID)
+		}
+		if sent := sender.sentTo(sub); len(sent) != 0 {
+			t.Errorf("subscription %d was sent %q", sub.ID, sent)
+		}
+	}
+}
+
+func TestRunDueJobsRunsManyJobsConcurrently(t *testing.T) {
+	s, sender := newTestSnapshotter(t)
+	subs := make([]*models.Subscription, 0)
+	for range 12 {
+		_, srv := newPage(t, "two")
+		subs = append(subs, subscribe(t, s, srv.URL, "one"))
+	}
+
+	runAgain(t, s)
+	for _, sub := range subs {
+		if got := sender.sentTo(sub); len(got) != 1 {
+			t.Errorf("subscription %d was sent %q, want one change", sub.ID, got)
+		}
+	}
+}
+
+func TestRunDueJobsChasesChangeAgain(t *testing.T) {
+	s, sender := newTestSnapshotter(t)
+	p, srv := newPage(t, "two")
+	sub := subscribe(t, s, srv.URL, "one")
+	runAgain(t, s)
+
+	// The chase is due before the subscription's next poll
+	p.set("three", http.StatusOK)
+	if err := s.db.Model(&models.Job{}).Where("kind = ?", models.JobChase).Update("run_at", time.Now().UTC()).Error; err != nil {
+		t.Fatal(err)
+	}
+	s.runDueJobs(context.Background(), time.Now().UTC())
+
+	if got := sender.sentTo(sub); len(got) != 2 || got[1] != "three" {
+		t.Errorf("subscription was sent %q, want [two three]", got)
+	}
+	var jobs models.Jobs
+	if err := s.db.Find(&jobs).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].Kind != models.JobChase {
+		t.Errorf("jobs left = %+v, want another chase", jobs)
+	}
+}
@@ -0,0 +1,308 @@
+package snapshotter
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/carlmjohnson/requests"
+)
+
+// RobotsDisallowedError is returned when a site's robots.txt does not allow our user agent to fetch an endpoint.
+type RobotsDisallowedError struct {
+	Endpoint  string
+	UserAgent string
+}
+
+func (e *RobotsDisallowedError) Error() string {
+	return fmt.Sprintf("robots.txt disallows %s from fetching %s", e.UserAgent, e.Endpoint)
+}
+
+const (
+	robotsTTL        = 24 * time.Hour   // How long to cache a site's robots.txt
+	robotsErrorTTL   = 10 * time.Minute // How long to treat a site as disallowed after failing to fetch its robots.txt
+	robotsTimeout    = 30 * time.Second // Gives up on fetching a robots.txt, including waiting for the host, after this
+	robotsMaxBytes   = 512 * 1024       // Anything past this in a robots.txt is ignored
+	robotsMaxEntries = 1024             // Origins cached at once; expired ones are dropped first when it's full
+	robotsAgentToken = "*"
+)
+
+// robotsCache fetches and caches robots.txt per origin, following RFC 9309.
+type robotsCache struct {
+	transport http.RoundTripper
+	hosts     *hostScheduler // Paces robots.txt requests along with the host's other requests
+	userAgent string
+	token     string // Product token matched against User-agent lines, e.g. "diffwatch" for "diffwatch/1.0"
+
+	mu      sync.Mutex
+	entries map[string]*robotsEntry
+}
+
+// robotsEntry is an origin's robots.txt, which is being downloaded until done is closed.
+type robotsEntry struct {
+	done    chan struct{}
+	rules   *robotsRules
+	err     error
+	expires time.Time
+}
+
+func newRobotsCache(transport http.RoundTripper, hosts *hostScheduler, userAgent string) *robotsCache {
+	token, _, _ := strings.Cut(userAgent, "/")
+	token = strings.ToLower(strings.TrimSpace(token))
+	return &robotsCache{
+		transport: transport,
+		hosts:     hosts,
+		userAgent: userAgent,
+		token:     token,
+		entries:   make(map[string]*robotsEntry),
+	}
+}
+
+// check returns a RobotsDisallowedError if robots.txt forbids fetching endpoint.
+func (c *robotsCache) check(ctx context.Context, endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+	if u.Path == "/robots.txt" {
+		return nil
+	}
+
+	rules, err := c.rulesFor(ctx, u)
+	if err != nil {
+		return err
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	if !rules.allows(c.token, path) {
+		return &RobotsDisallowedError{endpoint, c.userAgent}
+	}
+	return nil
+}
+
+// rulesFor returns the origin's cached rules, or waits for them to be downloaded once for all callers.
+func (c *robotsCache) rulesFor(ctx context.Context, u *url.URL) (*robotsRules, error) {
+	origin := strings.ToLower(u.Scheme + "://" + u.Host)
+
+	c.mu.Lock()
+	entry, ok := c.entries[origin]
+	if !ok || entry.expired(time.Now()) {
+		entry = &robotsEntry{done: make(chan struct{})}
+		c.evict()
+		c.entries[origin] = entry
+		go c.download(context.WithoutCancel(ctx), entry, u.Hostname(), origin)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-entry.done:
+		return entry.rules, entry.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (e *robotsEntry) downloaded() bool {
+	select {
+	case <-e.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (e *robotsEntry) expired(now time.Time) bool {
+	return e.downloaded() && !now.Before(e.expires)
+}
+
+// evict makes room for another entry, dropping expired entries and then the ones expiring soonest.
+func (c *robotsCache) evict() {
+	if len(c.entries) < robotsMaxEntries {
+		return
+	}
+	now := time.Now()
+	for origin, entry := range c.entries {
+		if entry.expired(now) {
+			delete(c.entries, origin)
+		}
+	}
+	for len(c.entries) >= robotsMaxEntries {
+		var oldest string
+		for origin, entry := range c.entries {
+			if entry.downloaded() && (oldest == "" || entry.expires.Before(c.entries[oldest].expires)) {
+				oldest = origin
+			}
+		}
+		if oldest == "" {
+			// Everything is still downloading
+			return
+		}
+		delete(c.entries, oldest)
+	}
+}
+
+// download fills in the entry; ctx isn't cancelled with its caller, who may stop waiting before the others do.
+func (c *robotsCache) download(ctx context.Context, entry *robotsEntry, host, origin string) {
+	defer close(entry.done)
+	ctx, cancel := context.WithTimeout(ctx, robotsTimeout)
+	defer cancel()
+
+	release, err := c.hosts.acquire(ctx, host)
+	if err != nil {
+		// Try again next time, rather than caching that the host couldn't be asked
+		entry.err = err
+		return
+	}
+	defer release()
+
+	entry.rules, entry.expires = c.fetch(ctx, host, origin)
+}
+
+// fetch gets an origin's robots.txt; per RFC 9309, a missing one allows all and an unreachable one disallows all.
+func (c *robotsCache) fetch(ctx context.Context, host, origin string) (*robotsRules, time.Time) {
+	var rules *robotsRules
+	err := requests.URL(origin + "/robots.txt").
+		Transport(c.transport).
+		UserAgent(c.userAgent).
+		AddValidator(nil).
+		Handle(func(res *http.Response) error {
+			c.hosts.observe(host, res)
+			switch {
+			case res.StatusCode >= 200 && res.StatusCode < 300:
+				rules = parseRobots(io.LimitReader(res.Body, robotsMaxBytes))
+			case res.StatusCode >= 400 && res.StatusCode < 500:
+				rules = &robotsRules{}
+			default:
+				return fmt.Errorf("unexpected status %d", res.StatusCode)
+			}
+			return nil
+		}).
+		Fetch(ctx)
+	if err != nil {
+		return &robotsRules{disallowAll: true}, time.Now().Add(robotsErrorTTL)
+	}
+	return rules, time.Now().Add(robotsTTL)
+}
+
+type robotsRules struct {
+	disallowAll bool
+	groups      []*robotsGroup
+}
+
+type robotsGroup struct {
+	agents []string
+	rules  []robotsRule
+}
+
+type robotsRule struct {
+	allow   bool
+	length  int // Length of the original pattern; the longest matching rule wins
+	pattern *regexp.Regexp
+}
+
+func parseRobots(r io.Reader) *robotsRules {
+	rules := &robotsRules{}
+	var group *robotsGroup
+	lastWasAgent := false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			if !lastWasAgent {
+				group = &robotsGroup{}
+				rules.groups = append(rules.groups, group)
+			}
+			group.agents = append(group.agents, strings.ToLower(value))
+			lastWasAgent = true
+
+		case "allow", "disallow":
+			lastWasAgent = false
+			if group == nil || value == "" {
+				continue
+			}
+			group.rules = append(group.rules, robotsRule{
+				allow:   key == "allow",
+				length:  len(value),
+				pattern: compileRobotsPattern(value),
+			})
+
+		default:
+			lastWasAgent = false
+		}
+	}
+	return rules
+}
+
+// compileRobotsPattern turns a path pattern, where * matches anything and a trailing $ anchors the end, into a regexp.
+func compileRobotsPattern(pattern string) *regexp.Regexp {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	expr := "^" + strings.Join(parts, ".*")
+	if anchored {
+		expr += "$"
+	}
+	return regexp.MustCompile(expr)
+}
+
+func (r *robotsRules) allows(token, path string) bool {
+	if r.disallowAll {
+		return false
+	}
+
+	groups := r.groupsFor(token)
+	if len(groups) == 0 {
+		groups = r.groupsFor(robotsAgentToken)
+	}
+
+	allowed, longest := true, -1
+	for _, group := range groups {
+		for _, rule := range group.rules {
+			if !rule.pattern.MatchString(path) {
+				continue
+			}
+			if rule.length > longest || (rule.length == longest && rule.allow) {
+				allowed, longest = rule.allow, rule.length
+			}
+		}
+	}
+	return allowed
+}
+
+func (r *robotsRules) groupsFor(token string) []*robotsGroup {
+	out := make([]*robotsGroup, 0)
+	for _, group := range r.groups {
+		for _, agent := range group.agents {
+			if agent == token {
+				out = append(out, group)
+				break
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,171 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const robotsTxt = `
+User-agent: *
+Disallow: /private/
+Allow: /private/open$
+
+User-agent: diffwatch
+User-agent: otherbot
+Disallow: /*.pdf$
+Disallow: /search
+Allow: /search/about # comments are ignored
+`
+
+func TestRobotsRulesAllows(t *testing.T) {
+	rules := parseRobots(strings.NewReader(robotsTxt))
+
+	tests := []struct {
+		token, path string
+		want        bool
+	}{
+		{"diffwatch", "/", true},
+		{"diffwatch", "/private/x", true}, // Only the most specific group applies
+		{"diffwatch", "/docs/a.pdf", false},
+		{"diffwatch", "/docs/a.pdf?x=1", true},
+		{"diffwatch", "/search?q=x", false},
+		{"diffwatch", "/search/about", true},
+		{"otherbot", "/search", false},
+		{"somebot", "/private/x", false},
+		{"somebot", "/private/open", true},
+		{"somebot", "/private/open/x", false},
+		{"somebot", "/search", true},
+	}
+	for _, tt := range tests {
+		if got := rules.allows(tt.token, tt.path); got != tt.want {
+			t.Errorf("allows(%q, %q) = %v, want %v", tt.token, tt.path, got, tt.want)
+		}
+	}
+}
+
+// robotsServer serves robotsTxt with the given status, counting the requests for it.
+func robotsServer(t *testing.T, status int, delay time.Duration) (*httptest.Server, *atomic.Int32) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/robots.txt" {
+			return
+		}
+		requests.Add(1)
+		time.Sleep(delay)
+		w.WriteHeader(status)
+		fmt.Fprint(w, robotsTxt)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func TestRobotsCacheCheck(t *testing.T) {
+	tests := []struct {
+		status int
+		path   string
+		want   bool
+	}{
+		{http.StatusOK, "/search", false},
+		{http.StatusOK, "/", true},
+		{http.StatusNotFound, "/search", true},               // A missing robots.txt allows everything
+		{http.StatusInternalServerError, "/anything", false}, // An unreachable one disallows everything
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d %s", tt.status, tt.path), func(t *testing.T) {
+			srv, _ := robotsServer(t, tt.status, 0)
+			c := newRobotsCache(http.DefaultTransport, newHostScheduler(politenessConfig{}), "diffwatch/1.0")
+
+			err := c.check(context.Background(), srv.URL+tt.path)
+			var disallowed *RobotsDisallowedError
+			if err != nil && !errors.As(err, &disallowed) {
+				t.Fatal(err)
+			}
+			if allowed := err == nil; allowed != tt.want {
+				t.Errorf("check() = %v, want allowed %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRobotsCacheDownloadsOnce(t *testing.T) {
+	srv, requests := robotsServer(t, http.StatusOK, 50*time.Millisecond)
+	c := newRobotsCache(http.DefaultTransport, newHostScheduler(politenessConfig{}), "diffwatch/1.0")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.check(context.Background(), srv.URL+"/"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := c.check(context.Background(), srv.URL+"/search"); err == nil {
+		t.Error("check() allowed a disallowed path")
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("robots.txt requested %d times, want once", n)
+	}
+}
+
+func TestRobotsCacheWaitsForHost(t *testing.T) {
+	srv, requests := robotsServer(t, http.StatusOK, 0)
+	hosts := newHostScheduler(politenessConfig{defaultBackoff: time.Hour})
+	hosts.observe("127.0.0.1", &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}})
+	c := newRobotsCache(http.DefaultTransport, hosts, "diffwatch/1.0")
+
+	var backoff *HostBackoffError
+	if err := c.check(context.Background(), srv.URL+"/"); !errors.As(err, &backoff) {
+		t.Errorf("check() = %v, want a HostBackoffError", err)
+	}
+	if n := requests.Load(); n != 0 {
+		t.Errorf("robots.txt requested %d times while backing off, want none", n)
+	}
+
+	// The failure isn't cached
+	c.hosts = newHostScheduler(politenessConfig{})
+	if err := c.check(context.Background(), srv.URL+"/"); err != nil {
+		t.Errorf("check() = %v after the backoff, want nil", err)
+	}
+}
+
+func TestRobotsCacheEvicts(t *testing.T) {
+	c := newRobotsCache(http.DefaultTransport, newHostScheduler(politenessConfig{}), "diffwatch/1.0")
+	done := make(chan struct{})
+	close(done)
+	now := time.Now()
+	for i := 0; i < robotsMaxEntries; i++ {
+		expires := now.Add(time.Hour + time.Duration(i)*time.Second)
+		if i%2 == 1 {
+			expires = now.Add(-time.Minute)
+		}
+		c.entries[fmt.Sprintf("https://%d.example.com", i)] = &robotsEntry{done: done, rules: &robotsRules{}, expires: expires}
+	}
+
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+	if got, want := len(c.entries), robotsMaxEntries/2; got != want {
+		t.Errorf("%d entries left after dropping expired ones, want %d", got, want)
+	}
+
+	for i := len(c.entries); i < robotsMaxEntries; i++ {
+		c.entries[fmt.Sprintf("https://new%d.example.com", i)] = &robotsEntry{done: done, rules: &robotsRules{}, expires: now.Add(2 * time.Hour)}
+	}
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+	if _, ok := c.entries["https://0.example.com"]; ok || len(c.entries) != robotsMaxEntries-1 {
+		t.Errorf("%d entries left, want the one expiring soonest dropped", len(c.entries))
+	}
+}
@@ -1,42 +1,81 @@
 package snapshotter
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"net/http"
-	"strings"
-	"sync"
+	"net/url"
 	"time"
 
 	"github.com/antchfx/htmlquery"
-	"github.com/carlmjohnson/requests"
+	"github.com/fiffu/diffwatch/config"
+	"github.com/fiffu/diffwatch/lib/blobs"
 	"github.com/fiffu/diffwatch/lib/models"
+	"github.com/fiffu/diffwatch/lib/proxies"
 	"github.com/fiffu/diffwatch/senders"
 	"go.uber.org/fx"
 	"go.uber.org/zap"
+	"golang.org/x/net/html"
 	"gorm.io/gorm"
 )
 
-var mu sync.Mutex
+func NewSnapshotter(lc fx.Lifecycle, cfg *config.Config, db *gorm.DB, log *zap.Logger, transport http.RoundTripper, senders senders.Registry, archive *blobs.Store) *Snapshotter {
+	settings := cfg.Snapshotter
+	log.Sugar().Infow("Snapshotter settings",
+		"wakeup_interval", settings.WakeupInterval,
+		"poll_interval", settings.PollInterval,
+		"chase_interval", settings.ChaseInterval,
+		"no_content_ttl", settings.NoContentTTL,
+		"snapshot_ttl", settings.SnapshotTTL,
+		"flap_window", settings.FlapWindow,
+		"concurrency", settings.Concurrency,
+		"run_timeout", settings.RunTimeout,
+		"job_timeout", settings.JobTimeout,
+		"lease_ttl", settings.LeaseTTL,
+	)
 
-func NewSnapshotter(lc fx.Lifecycle, db *gorm.DB, log *zap.Logger, transport http.RoundTripper, senders senders.Registry) *Snapshotter {
-	wakeupInterval := 30 * time.Minute // interval to check for pollable subscriptions
-	pollInterval := 1 * time.Hour      // poll each subscription every hour
-	chaseInterval := 10 * time.Minute  // if subscription updated, check again after this duration
-	noContentTTL := 7 * 24 * time.Hour // stop polling subscription if no data is returned for the past week
-	snapshotTTL := 14 * 24 * time.Hour // each snapshot is only preserved for 1 week
+	limits := fetchLimits{
+		maxBodyBytes:        cfg.Fetch.MaxBodyBytes,
+		allowedContentTypes: cfg.Fetch.AllowedContentTypes,
+	}
 
-	concurrency := 5
+	hosts := newHostScheduler(politenessConfig{
+		maxConcurrent:  cfg.Hosts.MaxConcurrency,
+		minInterval:    cfg.Hosts.MinInterval,
+		defaultBackoff: cfg.Hosts.DefaultBackoff,
+	})
+	var robots *robotsCache
+	if cfg.Hosts.RespectRobots {
+		robots = newRobotsCache(transport, hosts, cfg.Fetch.UserAgent)
+	}
 
+	proxyProfiles := make(map[string]*url.URL)
+	for name, rawURL := range cfg.Fetch.ProxyProfiles {
+		proxyURL, err := proxies.Parse(rawURL)
+		if err != nil {
+			log.Sugar().Errorw("Ignoring invalid proxy profile", "profile", name, "err", err)
+			continue
+		}
+		proxyProfiles[name] = proxyURL
+	}
+
+	intervals := Intervals{Poll: settings.PollInterval, Chase: settings.ChaseInterval}
 	snapshotter := Snapshotter{
-		db, log, transport, senders,
-		&mu, concurrency, NewAlarmClock(IntervalsConfig{Wakeup: wakeupInterval, Chase: chaseInterval}),
-		pollInterval, chaseInterval, noContentTTL, snapshotTTL,
+		db, log, transport, senders, archive,
+		settings.Concurrency, settings.WakeupInterval, newWorkerID(), settings.LeaseTTL,
+		nil, make(chan struct{}),
+		&runtimeIntervals{defaults: intervals, Intervals: intervals},
+		settings.NoContentTTL, settings.SnapshotTTL, settings.FlapWindow, settings.RunTimeout, settings.JobTimeout,
+		limits, cfg.Fetch.UserAgent, hosts, robots,
+		retryPolicy{cfg.Fetch.MaxRetries, cfg.Fetch.RetryBaseDelay},
+		cfg.Fetch.PauseAfterFailures,
+		proxyProfiles,
 	}
 
 	lc.Append(fx.Hook{
 		OnStart: func(ctx context.Context) error {
-			go snapshotter.Start(ctx)
+			snapshotter.Start(ctx)
 			return nil
 		},
 		OnStop: func(ctx context.Context) error {
@@ -54,51 +93,64 @@ type Snapshotter struct {
 	log       *zap.Logger
 	transport http.RoundTripper
 	senders   senders.Registry
+	archive   *blobs.Store // Raw responses of changed snapshots
+
+	concurrency    int           // Jobs run at once by this process
+	wakeupInterval time.Duration // How often to look for due jobs
+	workerID       string        // Identifies this process's leases on jobs
+	leaseTTL       time.Duration // Jobs are reclaimed by other workers if their lease isn't renewed within this
+	stop           func()        // Stops the worker loop once started
+	stopped        chan struct{} // Closed when the worker loop has finished
+
+	intervals    *runtimeIntervals // Poll and chase intervals, which can be changed in the settings table
+	noContentTTL time.Duration     // Purge subscription if it has no content for this duration
+	snapshotTTL  time.Duration     // Purge snapshots older than this
+	flapWindow   time.Duration     // Don't notify changes back to content seen within this; zero notifies them
+	runTimeout   time.Duration     // Stop claiming jobs once a wakeup's run has lasted this long
+	jobTimeout   time.Duration     // Each job is cancelled after this
+
+	limits    fetchLimits
+	userAgent string
+	hosts     *hostScheduler
+	robots    *robotsCache // Nil unless robots.txt is to be respected
 
-	mu          *sync.Mutex
-	concurrency int
-	alarmClock  *alarmClock
+	retries            retryPolicy
+	pauseAfterFailures int // Pause a subscription after this many consecutive failed polls; 0 never pauses
 
-	pollInterval  time.Duration // We only poll this subscription when the last poll this long ago
-	chaseInterval time.Duration // When a subscription is updated, we'll poll it again after this duration
-	noContentTTL  time.Duration // Purge subscription if it has no content for this duration
-	snapshotTTL   time.Duration // Purge snapshots older than this
+	proxyProfiles map[string]*url.URL
 }
 
+// Start runs the due jobs now and on every wakeup until Stop is called.
 func (s *Snapshotter) Start(ctx context.Context) {
-	c := s.alarmClock.Start()
+	if err := s.migrateChasers(ctx); err != nil {
+		s.log.Sugar().Errorw("Failed to migrate chasers into jobs", "err", err)
+	}
+
+	loopCtx, stop := context.WithCancel(context.Background())
+	s.stop = stop
 
 	go func() {
-		for evt := range c {
-			s.handleEvent(evt)
+		defer close(s.stopped)
+		ticker := time.NewTicker(s.wakeupInterval)
+		defer ticker.Stop()
+		for {
+			s.runDueJobs(loopCtx, time.Now().UTC())
+			select {
+			case <-ticker.C:
+			case <-loopCtx.Done():
+				return
+			}
 		}
 	}()
 }
 
+// Stop stops claiming jobs, and waits for those already claimed to finish.
 func (s *Snapshotter) Stop() {
-	mu.Lock()
-	s.alarmClock.Stop()
-	s.log.Sugar().Info("Snapshotter stopped")
-}
-
-func (s *Snapshotter) handleEvent(evt Event) {
-	mu.Lock()
-	defer mu.Unlock()
-
-	ctx := context.Background()
-	ctx, cancel := context.WithTimeout(ctx, 20*time.Second)
-	defer cancel()
-
-	switch evt.(type) {
-	case alarmStartupEvent:
-		s.pollSnapshots(ctx, evt.Timestamp())
-
-	case pollWakeupEvent:
-		s.pollSnapshots(ctx, evt.Timestamp())
-
-	case chaseWakeupEvent:
-		s.chaseSubscriptions(ctx, evt.Timestamp())
+	if s.stop != nil {
+		s.stop()
+		<-s.stopped
 	}
+	s.log.Sugar().Info("Snapshotter stopped")
 }
 
 func (s *Snapshotter) SendSnapshot(ctx context.Context, sub *models.Subscription, before, after *models.Snapshot) error {
@@ -116,21 +168,89 @@ func (s *Snapshotter) SendSnapshot(ctx context.Context, sub *models.Subscription
 	return err
 }
 
-func (s *Snapshotter) GetEndpointContent(ctx context.Context, endpoint, xpath string) (*models.EndpointContent, error) {
+// GetEndpointContent fetches the target and extracts its content, conditionally on the validators if any are given.
+func (s *Snapshotter) GetEndpointContent(ctx context.Context, target *models.Target, validators models.CacheValidators) (*models.EndpointContent, error) {
 	ret := &models.EndpointContent{}
 
-	var html string
-	err := requests.URL(endpoint).
-		Transport(s.transport).
-		ToString(&html).
-		Fetch(ctx)
-	doc, err := htmlquery.Parse(strings.NewReader(html))
+	res, err := s.fetch(ctx, target, validators)
+	ret.StatusCode = res.status
+	if err != nil {
+		return ret, err
+	}
+	ret.ETag, ret.LastModified = res.etag, res.lastModified
+	if res.notModified {
+		ret.NotModified = true
+		return ret, nil
+	}
+	ret.Raw = models.RawResponse{Header: res.header, Body: res.body}
+
+	kind, expr := target.Selector()
+	if kind == models.SelectorFeed {
+		base, _ := url.Parse(target.Endpoint)
+		feed, err := ParseFeed(res.body, res.contentType, target.Charset, base)
+		if err != nil {
+			return ret, fmt.Errorf("unable to read feed from %s: %w", target.Endpoint, err)
+		}
+		ret.SetEntries(feed.Entries)
+		ret.Title = feed.Title
+		ret.ImageURL = feed.ImageURL
+		return ret, nil
+	}
+
+	body, err := decodeBody(res.body, res.contentType, target.Charset, kind == models.SelectorJSONPath)
 	if err != nil {
 		return ret, err
 	}
+	switch kind {
+	case models.SelectorJSONPath:
+		path, err := CompileJSONPath(expr)
+		if err != nil {
+			return ret, err
+		}
+		doc, err := DecodeJSON(body)
+		if err != nil {
+			return ret, fmt.Errorf("unable to decode JSON from %s: %w", target.Endpoint, err)
+		}
+		if target.MultiNode {
+			ret.SetItems(SelectAllJSONText(doc, path))
+		} else {
+			ret.Text = SelectJSONText(doc, path)
+		}
 
-	ret.Text = SelectText(doc, xpath)
-	ret.Title = SelectText(doc, "/html/head/title")
-	ret.ImageURL = ExtractImageURL(doc)
+	case models.SelectorXPath, models.SelectorCSS:
+		var css *CSSSelector
+		if kind == models.SelectorCSS {
+			if css, err = CompileCSS(expr); err != nil {
+				return ret, err
+			}
+		}
+		doc, err := htmlquery.Parse(bytes.NewReader(body))
+		if err != nil {
+			return ret, err
+		}
+		var matches []*html.Node
+		switch {
+		case css != nil && target.MultiNode:
+			matches = css.MatchAll(doc)
+		case css != nil:
+			matches = []*html.Node{css.MatchOne(doc)}
+		case target.MultiNode:
+			matches = htmlquery.Find(doc, expr)
+		default:
+			matches = []*html.Node{htmlquery.FindOne(doc, expr)}
+		}
+		extractor := NewExtractor(&target.WatchSpec, doc)
+		if target.MultiNode {
+			ret.SetItems(extractor.ExtractAll(matches))
+		} else {
+			ret.Text = extractor.Extract(matches[0])
+		}
+		ret.Title = SelectText(doc, "/html/head/title")
+		ret.ImageURL = extractor.resolveAttr("src", ExtractImageURL(doc))
+
+	default:
+		return ret, fmt.Errorf("unsupported selector kind: %s", kind)
+	}
+	ret.Normalize(target.Normalize)
 	return ret, nil
 }
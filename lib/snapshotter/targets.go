@@ -0,0 +1,120 @@
+package snapshotter
+
+import (
+	"context"
+	"time"
+
+	"github.com/fiffu/diffwatch/lib/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SaveTarget returns the target with the candidate's spec, saving the candidate if there is none yet.
+func (s *Snapshotter) SaveTarget(ctx context.Context, candidate *models.Target) (*models.Target, error) {
+	candidate.Key = candidate.WatchSpec.Key()
+	tx := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(candidate)
+	if err := tx.Error; err != nil {
+		return nil, err
+	}
+	if tx.RowsAffected == 0 {
+		// Someone else watches this already
+		target := &models.Target{}
+		tx = s.db.Where(&models.Target{Key: candidate.Key}).First(target)
+		return target, tx.Error
+	}
+	return candidate, nil
+}
+
+// targetOf loads the subscription's target, linking subscriptions created before targets existed to one.
+func (s *Snapshotter) targetOf(ctx context.Context, sub *models.Subscription) (*models.Target, error) {
+	if sub.TargetID != 0 && sub.Target.ID == sub.TargetID {
+		return &sub.Target, nil
+	}
+	if sub.TargetID != 0 {
+		tx := s.db.First(&sub.Target, sub.TargetID)
+		return &sub.Target, tx.Error
+	}
+
+	target, err := s.SaveTarget(ctx, &models.Target{WatchSpec: sub.WatchSpec})
+	if err != nil {
+		return nil, err
+	}
+	tx := s.db.Model(sub).Update("target_id", target.ID)
+	if err := tx.Error; err != nil {
+		return nil, err
+	}
+	sub.Target = *target
+	return target, nil
+}
+
+// linkTargets links subscriptions created before targets existed to their targets, so that they are polled.
+func (s *Snapshotter) linkTargets(ctx context.Context) error {
+	var subs models.Subscriptions
+	tx := s.db.Where("target_id IS NULL OR target_id = 0").Find(&subs)
+	if err := tx.Error; err != nil {
+		return err
+	}
+	for _, sub := range subs {
+		if _, err := s.targetOf(ctx, sub); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// subscribersOf loads the target's subscriptions that match the scopes, with their notifiers.
+func (s *Snapshotter) subscribersOf(ctx context.Context, target *models.Target, scopes ...func(*gorm.DB) *gorm.DB) (models.Subscriptions, error) {
+	var subs models.Subscriptions
+	tx := s.db.
+		Scopes(scopes...).
+		Where("subscriptions.target_id = ?", target.ID).
+		InnerJoins("Notifier").
+		Find(&subs)
+	return subs, tx.Error
+}
+
+// polled limits a query to subscriptions that are still polled.
+func (s *Snapshotter) polled(now time.Time) func(*gorm.DB) *gorm.DB {
+	noContentCutoff := now.Add(-s.noContentTTL)
+	return func(db *gorm.DB) *gorm.DB {
+		return db.
+			Where("subscriptions.no_content_since IS NULL OR subscriptions.no_content_since > ?", noContentCutoff).
+			Where("subscriptions.paused_at IS NULL")
+	}
+}
+
+// due limits a query to subscriptions that are due to be polled.
+func due(now time.Time) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("subscriptions.next_poll_at IS NULL OR subscriptions.next_poll_at <= ?", now)
+	}
+}
+
+// sharedValidators returns the cache validators if every subscription holds the same ones, or none.
+func sharedValidators(subs models.Subscriptions) models.CacheValidators {
+	if len(subs) == 0 {
+		return models.CacheValidators{}
+	}
+	for _, sub := range subs[1:] {
+		if sub.CacheValidators != subs[0].CacheValidators {
+			return models.CacheValidators{}
+		}
+	}
+	return subs[0].CacheValidators
+}
+
+// saveCacheValidators remembers the validators of the response the subscription's latest snapshot was taken from.
+func (s *Snapshotter) saveCacheValidators(ctx context.Context, sub *models.Subscription, validators models.CacheValidators) error {
+	if sub.CacheValidators == validators {
+		return nil
+	}
+	tx := s.db.Model(sub).Updates(map[string]any{
+		"etag":          validators.ETag,
+		"last_modified": validators.LastModified,
+	})
+	if err := tx.Error; err != nil {
+		return err
+	}
+	sub.CacheValidators = validators
+	return nil
+}
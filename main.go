@@ -40,6 +40,7 @@ func main() {
 		fx.Provide(snapshotter.NewSnapshotter),
 		fx.Provide(lib.NewService),
 		fx.Provide(app.NewDatabase),
+		fx.Provide(app.NewBlobStore),
 		fx.Provide(app.NewTransport),
 		fx.Provide(app.NewAPI),
 
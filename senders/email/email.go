@@ -6,6 +6,7 @@ import (
 	"html/template"
 	"strings"
 
+	"github.com/fiffu/diffwatch/lib/diff"
 	"github.com/fiffu/diffwatch/lib/models"
 )
 
@@ -14,6 +15,10 @@ var (
 	snapshotHTML     string
 	snapshotTemplate = template.Must(template.New("snapshot.html").Parse(snapshotHTML))
 
+	//go:embed digest.html
+	digestHTML     string
+	digestTemplate = template.Must(template.Must(snapshotTemplate.Clone()).New("digest.html").Parse(digestHTML))
+
 	//go:embed verify.html
 	verifyHTML     string
 	verifyTemplate = template.Must(template.New("verify.html").Parse(verifyHTML))
@@ -38,13 +43,90 @@ func (ef *SnapshotEmailFormat) Subject() string {
 	if ef.Previous != nil {
 		re = "Re: "
 	}
-	return fmt.Sprintf("%sDiffwatch: new update on %s", re, ef.Subscription.Title)
+	return fmt.Sprintf("%sDiffwatch: new update on %s", re, ef.Subscription.DisplayTitle())
 }
 
 func (ef *SnapshotEmailFormat) Body() string {
 	return mustFillTemplate(snapshotTemplate, ef)
 }
 
+// IsList reports whether the email shows added and removed items rather than the content.
+func (ef *SnapshotEmailFormat) IsList() bool {
+	return ef.Current.IsList()
+}
+
+func (ef *SnapshotEmailFormat) AddedItems() models.Items {
+	added, _ := ef.diffItems()
+	return added
+}
+
+func (ef *SnapshotEmailFormat) RemovedItems() models.Items {
+	_, removed := ef.diffItems()
+	return removed
+}
+
+func (ef *SnapshotEmailFormat) diffItems() (added, removed models.Items) {
+	var prev models.Items
+	if ef.Previous != nil {
+		prev = ef.Previous.Items
+	}
+	return models.DiffItems(prev, ef.Current.Items)
+}
+
+// IsFeed reports whether the snapshot was taken from a feed, in which case the email lists new and edited entries.
+func (ef *SnapshotEmailFormat) IsFeed() bool {
+	return ef.Current.IsFeed()
+}
+
+func (ef *SnapshotEmailFormat) NewEntries() models.FeedEntries {
+	added, _ := ef.diffEntries()
+	return added
+}
+
+func (ef *SnapshotEmailFormat) EditedEntries() models.FeedEntries {
+	_, edited := ef.diffEntries()
+	return edited
+}
+
+func (ef *SnapshotEmailFormat) diffEntries() (added, edited models.FeedEntries) {
+	var prev models.FeedEntries
+	if ef.Previous != nil {
+		prev = ef.Previous.Entries
+	}
+	return models.DiffFeedEntries(prev, ef.Current.Entries)
+}
+
+// Diff returns the changes from the previous snapshot, computing them if the snapshot predates stored diffs.
+func (ef *SnapshotEmailFormat) Diff() diff.Hunks {
+	if ef.Previous == nil {
+		return nil
+	}
+	if ef.Current.Diff != nil {
+		return ef.Current.Diff
+	}
+	return diff.Compute(ef.Previous.Content, ef.Current.Content)
+}
+
+// DigestEmailFormat lists several changes in one email, each shown as it would be in its own email.
+type DigestEmailFormat struct {
+	Changes []*SnapshotEmailFormat
+}
+
+func (ef *DigestEmailFormat) Subject() string {
+	return fmt.Sprintf("Diffwatch: digest of %d %s", len(ef.Changes), plural(len(ef.Changes), "update", "updates"))
+}
+
+func (ef *DigestEmailFormat) Body() string {
+	return mustFillTemplate(digestTemplate, ef)
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
+
 type VerificationEmailFormat struct {
 	VerifyURL string
 }
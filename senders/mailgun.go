@@ -39,6 +39,18 @@ func (e *mailgunSender) SendSnapshot(ctx context.Context, notifier *models.Notif
 	return e.send(ctx, formatter, notifier.PlatformIdentifier)
 }
 
+func (e *mailgunSender) SendDigest(ctx context.Context, notifier *models.Notifier, pending models.PendingNotifications) (string, error) {
+	formatter := &email.DigestEmailFormat{}
+	for _, p := range pending {
+		formatter.Changes = append(formatter.Changes, &email.SnapshotEmailFormat{
+			Subscription: &p.Subscription,
+			Previous:     p.Change.Previous,
+			Current:      p.Change.Current,
+		})
+	}
+	return e.send(ctx, formatter, notifier.PlatformIdentifier)
+}
+
 func (e *mailgunSender) SendVerification(ctx context.Context, notifier *models.Notifier, verifyURL string) (string, error) {
 	formatter := &email.VerificationEmailFormat{VerifyURL: verifyURL}
 	return e.send(ctx, formatter, notifier.PlatformIdentifier)
@@ -12,6 +12,7 @@ import (
 
 type Sender interface {
 	SendSnapshot(ctx context.Context, notifier *models.Notifier, sub *models.Subscription, before, after *models.Snapshot) (string, error)
+	SendDigest(ctx context.Context, notifier *models.Notifier, pending models.PendingNotifications) (string, error)
 	SendVerification(ctx context.Context, notifier *models.Notifier, verifyURL string) (string, error)
 }
 